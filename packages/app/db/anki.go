@@ -0,0 +1,617 @@
+package db
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rep2recall/r2r/shared"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ankiNamespace makes IDs of imported Anki notes, models, templates stable, so that importing again updates them
+var ankiNamespace = uuid.MustParse("5b1f1c56-3c4e-4b8e-9a67-2f0b8f6f4d21")
+
+type ankiModel struct {
+	Name string `json:"name"`
+	Type int    `json:"type"` // 0 for standard, 1 for cloze
+	CSS  string `json:"css"`
+	Flds []struct {
+		Name string `json:"name"`
+		Ord  int    `json:"ord"`
+	} `json:"flds"`
+	Tmpls []struct {
+		Name string `json:"name"`
+		Qfmt string `json:"qfmt"`
+		Afmt string `json:"afmt"`
+		Ord  int    `json:"ord"`
+	} `json:"tmpls"`
+}
+
+type ankiDeck struct {
+	Name string `json:"name"`
+}
+
+func ankiID(kind string, id string) string {
+	return uuid.NewSHA1(ankiNamespace, []byte(kind+":"+id)).String()
+}
+
+// ImportAnki loads an Anki .apkg or .colpkg, importing media files referenced by notes and templates, as Media.
+// Note types become models, card types become templates, and review history becomes scheduling state.
+func ImportAnki(tx *gorm.DB, f string, opts LoadOptions) (LoadSummary, error) {
+	summary := LoadSummary{
+		File: f,
+	}
+
+	zr, e := zip.OpenReader(f)
+	if e != nil {
+		return summary, e
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File)
+	for _, zf := range zr.File {
+		files[zf.Name] = zf
+	}
+
+	col := files["collection.anki21"]
+	if col == nil {
+		col = files["collection.anki2"]
+	}
+	if col == nil {
+		if files["collection.anki21b"] != nil {
+			return summary, errors.New("compressed collections are not supported, export with \"Support older Anki versions\" instead")
+		}
+		return summary, errors.New("not an Anki package: " + f)
+	}
+
+	tmp, e := os.CreateTemp("", "r2r-anki-*.db")
+	if e != nil {
+		return summary, e
+	}
+	defer os.Remove(tmp.Name())
+
+	if e := extractZipFile(col, tmp); e != nil {
+		tmp.Close()
+		return summary, e
+	}
+	tmp.Close()
+
+	ankiDB, e := sql.Open("sqlite3", tmp.Name())
+	if e != nil {
+		return summary, e
+	}
+	defer ankiDB.Close()
+
+	loadFile, e := readAnki(ankiDB)
+	if e != nil {
+		return summary, e
+	}
+
+	mediaDir, e := os.MkdirTemp("", "r2r-anki-media-*")
+	if e != nil {
+		return summary, e
+	}
+	defer os.RemoveAll(mediaDir)
+
+	// Before notes are saved, so that references are saved as MediaURL
+	var noteMedia []NoteMedia
+	var mediaCopies []mediaCopy
+	if mf := files["media"]; mf != nil {
+		noteMedia, mediaCopies, e = importAnkiMedia(tx, mf, files, mediaDir, &loadFile)
+		if e != nil {
+			return summary, e
+		}
+	}
+
+	summary, e = LoadFrom(tx, f, loadFile, opts)
+	if e != nil {
+		return summary, e
+	}
+
+	// After notes are saved; replacing media, which are no longer referenced
+	keys := make(map[string][]string)
+	for _, nm := range noteMedia {
+		keys[nm.NoteID] = append(keys[nm.NoteID], nm.Key)
+	}
+	for _, n := range loadFile.Note {
+		rTx := tx.Where("note_id = ?", n.ID)
+		if len(keys[n.ID]) > 0 {
+			rTx = rTx.Where("key NOT IN ?", keys[n.ID])
+		}
+		if r := rTx.Delete(&NoteMedia{}); r.Error != nil {
+			return summary, r.Error
+		}
+	}
+
+	for _, nm := range noteMedia {
+		if r := tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.AssignmentColumns([]string{"hash"}),
+		}).Create(&nm); r.Error != nil {
+			return summary, r.Error
+		}
+	}
+
+	// Not on dry runs, as rolled back
+	if !opts.Diff {
+		for _, c := range mediaCopies {
+			if e := copyMedia(c.media, c.path); e != nil {
+				return summary, e
+			}
+		}
+	}
+
+	return summary, nil
+}
+
+func extractZipFile(zf *zip.File, w io.Writer) error {
+	r, e := zf.Open()
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+
+	_, e = io.Copy(w, r)
+	return e
+}
+
+// importAnkiMedia creates Media of files, numbered in the package, by their names in the `media` JSON,
+// extracting them into dir; and replaces references in notes and templates by MediaURL.
+// Only referenced files are imported; and attached, by name, to notes referencing them, or of models referencing them.
+func importAnkiMedia(tx *gorm.DB, mf *zip.File, files map[string]*zip.File, dir string, loadFile *LoadedStruct) ([]NoteMedia, []mediaCopy, error) {
+	r, e := mf.Open()
+	if e != nil {
+		return nil, nil, e
+	}
+	defer r.Close()
+
+	media := make(map[string]string)
+	if e := json.NewDecoder(r).Decode(&media); e != nil {
+		shared.Logger.Printf("media list is not JSON, skipping media: %v\n", e)
+		return nil, nil, nil
+	}
+
+	nums := make(map[string]string)
+	for num, name := range media {
+		nums[name] = num
+	}
+
+	modelNames := make(map[string][]string)
+	for _, m := range loadFile.Model {
+		modelNames[m.ID] = append(modelNames[m.ID], ankiMediaNames(m.Shared, nums)...)
+	}
+	for _, t := range loadFile.Template {
+		modelNames[t.ModelID] = append(modelNames[t.ModelID], ankiMediaNames(t.Front+t.Back, nums)...)
+	}
+
+	noteNames := make(map[string][]string)
+	referenced := make(map[string]bool)
+	for _, n := range loadFile.Note {
+		names := append([]string{}, modelNames[n.ModelID]...)
+		for _, v := range n.Data {
+			if s, ok := v.(string); ok {
+				names = append(names, ankiMediaNames(s, nums)...)
+			}
+		}
+
+		for _, name := range names {
+			referenced[name] = true
+		}
+		noteNames[n.ID] = names
+	}
+
+	sorted := make([]string, 0)
+	for name := range referenced {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	hashes := make(map[string]string)
+	copies := make([]mediaCopy, 0)
+	for _, name := range sorted {
+		zf := files[nums[name]]
+		base := filepath.Base(name)
+		if zf == nil || base == "." || base == ".." || base == string(filepath.Separator) {
+			shared.Logger.Printf("missing media file: %s\n", name)
+			continue
+		}
+
+		p := filepath.Join(dir, base)
+		out, e := os.Create(p)
+		if e != nil {
+			return nil, nil, e
+		}
+
+		if e := extractZipFile(zf, out); e != nil {
+			out.Close()
+			return nil, nil, e
+		}
+
+		if e := out.Close(); e != nil {
+			return nil, nil, e
+		}
+
+		m, e := createMedia(tx, p)
+		if e != nil {
+			return nil, nil, e
+		}
+		copies = append(copies, mediaCopy{
+			media: m,
+			path:  p,
+		})
+		hashes[name] = m.Hash
+	}
+
+	for i, m := range loadFile.Model {
+		loadFile.Model[i].Shared = ankiMediaReplace(m.Shared, nums, hashes)
+	}
+	for i, t := range loadFile.Template {
+		loadFile.Template[i].Front = ankiMediaReplace(t.Front, nums, hashes)
+		loadFile.Template[i].Back = ankiMediaReplace(t.Back, nums, hashes)
+	}
+
+	out := make([]NoteMedia, 0)
+	for _, n := range loadFile.Note {
+		for k, v := range n.Data {
+			if s, ok := v.(string); ok {
+				n.Data[k] = ankiMediaReplace(s, nums, hashes)
+			}
+		}
+
+		attached := make(map[string]bool)
+		for _, name := range noteNames[n.ID] {
+			if hashes[name] == "" || attached[name] {
+				continue
+			}
+			attached[name] = true
+
+			out = append(out, NoteMedia{
+				NoteID: n.ID,
+				Key:    name,
+				Hash:   hashes[name],
+			})
+		}
+	}
+
+	return out, copies, nil
+}
+
+// ankiMediaRegex matches references to media in Anki fields and templates, as `[sound:]`, `src=` and CSS `url()`
+var ankiMediaRegex = regexp.MustCompile(`(?i)\[sound:([^\]]+)\]|\bsrc\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))|\burl\(\s*(?:"([^"]*)"|'([^']*)'|([^\s"')]+))\s*\)`)
+
+// ankiMediaName is the name, in nums, of media referenced by match of ankiMediaRegex; which may be HTML or URL escaped
+func ankiMediaName(match []string, nums map[string]string) (string, bool) {
+	ref := ""
+	for _, m := range match[1:] {
+		if m != "" {
+			ref = m
+			break
+		}
+	}
+
+	candidates := []string{ref, html.UnescapeString(ref)}
+	if s, e := url.PathUnescape(html.UnescapeString(ref)); e == nil {
+		candidates = append(candidates, s)
+	}
+
+	for _, name := range candidates {
+		if _, ok := nums[name]; ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// ankiMediaNames are names of media in nums, referenced in s
+func ankiMediaNames(s string, nums map[string]string) []string {
+	out := make([]string, 0)
+	for _, match := range ankiMediaRegex.FindAllStringSubmatch(s, -1) {
+		if name, ok := ankiMediaName(match, nums); ok {
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+// ankiMediaReplace replaces references to media in s by MediaURL of hashes, by name; sounds become `<audio>`.
+// References to missing media are kept.
+func ankiMediaReplace(s string, nums map[string]string, hashes map[string]string) string {
+	return ankiMediaRegex.ReplaceAllStringFunc(s, func(m string) string {
+		match := ankiMediaRegex.FindStringSubmatch(m)
+		name, ok := ankiMediaName(match, nums)
+		if !ok || hashes[name] == "" {
+			return m
+		}
+
+		u := MediaURL(hashes[name])
+		switch {
+		case match[1] != "":
+			return fmt.Sprintf(`<audio controls src="%s"></audio>`, u)
+		case strings.HasPrefix(strings.ToLower(m), "url("):
+			return fmt.Sprintf(`url("%s")`, u)
+		}
+
+		return fmt.Sprintf(`src="%s"`, u)
+	})
+}
+
+func readAnki(ankiDB *sql.DB) (LoadedStruct, error) {
+	out := LoadedStruct{}
+
+	var crt int64
+	var modelsJSON, decksJSON string
+	if e := ankiDB.QueryRow("SELECT crt, models, decks FROM col").Scan(&crt, &modelsJSON, &decksJSON); e != nil {
+		return out, e
+	}
+
+	models := make(map[string]ankiModel)
+	if e := json.Unmarshal([]byte(modelsJSON), &models); e != nil {
+		return out, e
+	}
+	if len(models) == 0 {
+		return out, errors.New("no note types in collection, export with \"Support older Anki versions\" instead")
+	}
+
+	decks := make(map[string]ankiDeck)
+	if e := json.Unmarshal([]byte(decksJSON), &decks); e != nil {
+		return out, e
+	}
+
+	mids := make([]string, 0)
+	for mid := range models {
+		mids = append(mids, mid)
+	}
+	sort.Strings(mids)
+
+	for _, mid := range mids {
+		m := models[mid]
+
+		css := ""
+		if strings.TrimSpace(m.CSS) != "" {
+			css = "<style>\n" + m.CSS + "\n</style>\n"
+		}
+
+		out.Model = append(out.Model, LoadedModelStruct{
+			ID:     ankiID("model", mid),
+			Name:   m.Name,
+			Shared: css,
+		})
+
+		if m.Type == 1 {
+			shared.Logger.Printf("cloze deletions of %s are imported as a single card\n", m.Name)
+		}
+
+		for _, t := range m.Tmpls {
+			front := ankiToEta(t.Qfmt)
+			out.Template = append(out.Template, LoadedTemplateStruct{
+				ID:      ankiID("template", fmt.Sprintf("%s:%d", mid, t.Ord)),
+				ModelID: ankiID("model", mid),
+				Name:    t.Name,
+				Front:   front,
+				Back:    strings.ReplaceAll(ankiToEta(strings.ReplaceAll(t.Afmt, "{{FrontSide}}", "\x00")), "\x00", front),
+			})
+
+			if m.Type == 1 {
+				break
+			}
+		}
+	}
+
+	rows, e := ankiDB.Query("SELECT id, guid, mid, tags, flds FROM notes ORDER BY id")
+	if e != nil {
+		return out, e
+	}
+	defer rows.Close()
+
+	noteIDs := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var guid, mid, tags, flds string
+		if e := rows.Scan(&id, &guid, &mid, &tags, &flds); e != nil {
+			return out, e
+		}
+
+		m, ok := models[mid]
+		if !ok {
+			shared.Logger.Printf("unknown note type %s of note %s\n", mid, guid)
+			continue
+		}
+
+		data := make(map[string]interface{})
+		values := strings.Split(flds, "\x1f")
+		for _, fld := range m.Flds {
+			if fld.Ord < len(values) {
+				data[fld.Name] = values[fld.Ord]
+			}
+		}
+
+		tag := make([]string, 0)
+		for _, t := range strings.Fields(tags) {
+			if ValidateTag(t) == nil {
+				tag = append(tag, t)
+			}
+		}
+
+		noteIDs[id] = ankiID("note", guid)
+		out.Note = append(out.Note, LoadedNoteStruct{
+			Key:     "anki-" + guid,
+			ID:      noteIDs[id],
+			ModelID: ankiID("model", mid),
+			Data:    data,
+			Tag:     tag,
+		})
+	}
+	if e := rows.Err(); e != nil {
+		return out, e
+	}
+
+	reviews := make(map[int64][]ankiReview)
+	revRows, e := ankiDB.Query("SELECT cid, id, ease FROM revlog ORDER BY id")
+	if e != nil {
+		return out, e
+	}
+	defer revRows.Close()
+
+	for revRows.Next() {
+		var cid int64
+		var r ankiReview
+		if e := revRows.Scan(&cid, &r.ID, &r.Ease); e != nil {
+			return out, e
+		}
+		reviews[cid] = append(reviews[cid], r)
+	}
+	if e := revRows.Err(); e != nil {
+		return out, e
+	}
+
+	cardRows, e := ankiDB.Query(`
+	SELECT cards.id, cards.nid, notes.mid, cards.did, cards.ord, cards.type, cards.queue, cards.due, cards.ivl
+	FROM cards JOIN notes ON notes.id = cards.nid
+	ORDER BY cards.id`)
+	if e != nil {
+		return out, e
+	}
+	defer cardRows.Close()
+
+	out.cards = make(map[string]bool)
+	for cardRows.Next() {
+		var id, nid, did, due int64
+		var mid string
+		var ord, cardType, queue, ivl int
+		if e := cardRows.Scan(&id, &nid, &mid, &did, &ord, &cardType, &queue, &due, &ivl); e != nil {
+			return out, e
+		}
+
+		if models[mid].Type == 1 {
+			ord = 0
+		}
+
+		st := LoadedStateStruct{
+			TemplateID: ankiID("template", fmt.Sprintf("%s:%d", mid, ord)),
+			NoteID:     noteIDs[nid],
+		}
+		if st.NoteID == "" || out.cards[st.TemplateID+"/"+st.NoteID] {
+			continue
+		}
+		out.cards[st.TemplateID+"/"+st.NoteID] = true
+
+		if d, ok := decks[strconv.FormatInt(did, 10)]; ok {
+			t := strings.Join(strings.Fields(d.Name), "_")
+			if ValidateTag(t) == nil {
+				st.Tag = []string{t}
+			}
+		}
+
+		if cardType != 0 {
+			st.SRSLevel = ankiSRSLevel(ivl)
+
+			var nextReview time.Time
+			if queue == 1 || (queue < 0 && cardType == 1) {
+				// Learning cards are due in seconds
+				nextReview = time.Unix(due, 0)
+			} else {
+				// Review cards are due in days since the collection was created
+				nextReview = time.Unix(crt+due*24*60*60, 0)
+			}
+			st.NextReview = &nextReview
+		}
+
+		for _, r := range reviews[id] {
+			at := time.Unix(0, r.ID*int64(time.Millisecond))
+
+			// Same as Card.UpdateSRSLevel
+			if r.Ease == 1 {
+				st.LastWrong = &at
+				st.WrongStreak++
+				if st.MaxWrong < st.WrongStreak {
+					st.MaxWrong = st.WrongStreak
+				}
+			} else {
+				st.LastRight = &at
+				st.RightStreak++
+				if st.MaxRight < st.RightStreak {
+					st.MaxRight = st.RightStreak
+				}
+			}
+		}
+
+		out.State = append(out.State, st)
+	}
+	if e := cardRows.Err(); e != nil {
+		return out, e
+	}
+
+	return out, nil
+}
+
+type ankiReview struct {
+	ID   int64 // Milliseconds since epoch
+	Ease int   // 1 for wrong
+}
+
+// ankiSRSLevel is the highest SRS level, whose interval is within the Anki interval,
+// in days, or in seconds if negative
+func ankiSRSLevel(ivl int) int {
+	d := time.Duration(ivl) * 24 * time.Hour
+	if ivl < 0 {
+		d = time.Duration(-ivl) * time.Second
+	}
+
+	level := 0
+	for i, s := range srsMap {
+		if s <= d {
+			level = i
+		}
+	}
+
+	return level
+}
+
+var ankiMustacheRegex = regexp.MustCompile(`\{\{([#^/]?)\s*([^}]*?)\s*\}\}`)
+
+// ankiToEta converts simple Mustache of Anki card templates to Eta.
+// Filters, such as `text:` and `cloze:`, are dropped; and special fields, such as `Tags`, are left empty.
+func ankiToEta(s string) string {
+	return ankiMustacheRegex.ReplaceAllStringFunc(s, func(m string) string {
+		match := ankiMustacheRegex.FindStringSubmatch(m)
+		sign, name := match[1], match[2]
+
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			name = name[i+1:]
+		}
+
+		key, _ := json.Marshal(name)
+
+		switch sign {
+		case "#":
+			return fmt.Sprintf("<%% if (it[%s]) { %%>", key)
+		case "^":
+			return fmt.Sprintf("<%% if (!it[%s]) { %%>", key)
+		case "/":
+			return "<% } %>"
+		}
+
+		switch name {
+		case "Tags", "Type", "Deck", "Subdeck", "Card", "CardFlag":
+			return ""
+		}
+
+		return fmt.Sprintf("<%%~ it[%s] || '' %%>", key)
+	})
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"archive/zip"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnkiToEta(t *testing.T) {
+	out := ankiToEta(`{{#Back Side}}{{text:Back Side}}{{/Back Side}}{{^Front}}none{{/Front}}{{Tags}}`)
+	expected := `<% if (it["Back Side"]) { %><%~ it["Back Side"] || '' %><% } %><% if (!it["Front"]) { %>none<% } %>`
+	if out != expected {
+		t.Errorf("expected [%s], got [%s]", expected, out)
+	}
+}
+
+// testApkg makes an Anki package with a single note, reviewed twice; of two card types, only one of which has a card
+func testApkg(t *testing.T, dir string) string {
+	colPath := filepath.Join(dir, "collection.anki2")
+	col, e := sql.Open("sqlite3", colPath)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE col (crt INTEGER, models TEXT, decks TEXT)`,
+		`CREATE TABLE notes (id INTEGER, guid TEXT, mid INTEGER, tags TEXT, flds TEXT)`,
+		`CREATE TABLE cards (id INTEGER, nid INTEGER, did INTEGER, ord INTEGER, type INTEGER, queue INTEGER, due INTEGER, ivl INTEGER)`,
+		`CREATE TABLE revlog (id INTEGER, cid INTEGER, ease INTEGER)`,
+		`INSERT INTO col VALUES (1600000000, '{"1":{"name":"Basic","type":0,"css":".card { background: url(''_bg.png''); }","flds":[{"name":"Front","ord":0},{"name":"Back","ord":1}],"tmpls":[{"name":"Card 1","qfmt":"{{Front}}","afmt":"{{FrontSide}}<hr>{{Back}}","ord":0},{"name":"Card 2","qfmt":"{{Back}}","afmt":"{{FrontSide}}<hr>{{Front}}","ord":1}]}}', '{"1":{"name":"My Deck"}}')`,
+		`INSERT INTO notes VALUES (10, 'abc', 1, ' vocab::n5 ', 'hello[sound:hello.mp3]' || char(31) || 'world<img src="world &amp; more.jpg">')`,
+		`INSERT INTO cards VALUES (100, 10, 1, 0, 2, 2, 10, 8)`,
+		`INSERT INTO revlog VALUES (1600000000000, 100, 1)`,
+		`INSERT INTO revlog VALUES (1600086400000, 100, 3)`,
+	} {
+		if _, e := col.Exec(stmt); e != nil {
+			t.Fatal(e)
+		}
+	}
+	col.Close()
+
+	apkg := filepath.Join(dir, "deck.apkg")
+	f, e := os.Create(apkg)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range map[string][]byte{
+		"media": []byte(`{"0": "hello.mp3", "1": "world & more.jpg", "2": "_bg.png", "3": "unused.png"}`),
+		"0":     []byte("mp3"),
+		"1":     []byte("jpg"),
+		"2":     []byte("png"),
+		"3":     []byte("unused"),
+	} {
+		w, e := zw.Create(name)
+		if e != nil {
+			t.Fatal(e)
+		}
+		w.Write(content)
+	}
+
+	b, e := os.ReadFile(colPath)
+	if e != nil {
+		t.Fatal(e)
+	}
+	w, e := zw.Create("collection.anki2")
+	if e != nil {
+		t.Fatal(e)
+	}
+	w.Write(b)
+
+	if e := zw.Close(); e != nil {
+		t.Fatal(e)
+	}
+
+	return apkg
+}
+
+func TestImportAnki(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, nil)
+	apkg := testApkg(t, t.TempDir())
+
+	dryRun := tx.Begin()
+	if _, e := ImportAnki(dryRun, apkg, LoadOptions{Diff: true}); e != nil {
+		t.Fatal(e)
+	}
+	dryRun.Rollback()
+	if _, e := os.Stat(filepath.Join(dir, "media")); !os.IsNotExist(e) {
+		t.Errorf("expected no media copied on dry run, got %v", e)
+	}
+
+	s, e := ImportAnki(tx, apkg, LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.Note.Created != 1 || s.Template.Created != 2 || s.Card.Created != 1 {
+		t.Fatalf("expected 1 note, 2 templates and 1 card created, got note [%s], template [%s], card [%s]", s.Note, s.Template, s.Card)
+	}
+
+	var c Card
+	if r := tx.Preload("Template.Model").First(&c); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	expectedBack := `<%~ it["Front"] || '' %><hr><%~ it["Back"] || '' %>`
+	if c.Template.Back != expectedBack {
+		t.Errorf("expected back [%s], got [%s]", expectedBack, c.Template.Back)
+	}
+
+	nextReview := time.Unix(1600000000+10*24*60*60, 0)
+	if c.SRSLevel != 4 || c.NextReview == nil || !c.NextReview.Equal(nextReview) {
+		t.Errorf("expected SRS level 4 due at %v, got %d due at %v", nextReview, c.SRSLevel, c.NextReview)
+	}
+	if c.RightStreak != 1 || c.WrongStreak != 1 || tagString(c.Tag) != "My_Deck" {
+		t.Errorf("expected review history and deck tag, got %+v", c)
+	}
+
+	hashes := make(map[string]string)
+	var attached []NoteMedia
+	if r := tx.Order("key").Find(&attached); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	for _, nm := range attached {
+		hashes[nm.Key] = nm.Hash
+		if _, e := os.Stat(MediaPath(nm.Hash)); e != nil {
+			t.Error(e)
+		}
+	}
+	if len(attached) != 3 || hashes["hello.mp3"] == "" || hashes["world & more.jpg"] == "" || hashes["_bg.png"] == "" {
+		t.Errorf("expected referenced media attached, got %+v", attached)
+	}
+
+	var count int64
+	tx.Model(&Media{}).Count(&count)
+	if count != 3 {
+		t.Errorf("expected only referenced media imported, got %d", count)
+	}
+
+	var attrs []NoteAttr
+	if r := tx.Order("key").Find(&attrs); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	expected := []string{
+		`hello<audio controls src="` + MediaURL(hashes["hello.mp3"]) + `"></audio>`,
+		`world<img src="` + MediaURL(hashes["world & more.jpg"]) + `">`,
+	}
+	if len(attrs) != 2 || attrs[1].Value.Raw != expected[0] || attrs[0].Value.Raw != expected[1] {
+		t.Errorf("expected references replaced by media URLs, got %+v", attrs)
+	}
+
+	expectedShared := "url(\"" + MediaURL(hashes["_bg.png"]) + "\")"
+	if !strings.Contains(c.Template.Model.Shared, expectedShared) {
+		t.Errorf("expected [%s] in shared, got [%s]", expectedShared, c.Template.Model.Shared)
+	}
+
+	// Importing again updates, rather than duplicates
+	s, e = ImportAnki(tx, apkg, LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.Note.Unchanged != 1 || s.Card.Unchanged != 1 {
+		t.Errorf("expected note and card unchanged, got note [%s], card [%s]", s.Note, s.Card)
+	}
+}
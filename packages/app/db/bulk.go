@@ -0,0 +1,192 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SuspendedTag is the card tag, which excludes cards from quizzes; searched as `is:suspended`
+const SuspendedTag = "suspended"
+
+// BulkOps are operations of BulkCards
+var BulkOps = []string{"tag", "untag", "suspend", "unsuspend", "reset", "reschedule", "spread", "srsLevel", "delete"}
+
+// BulkOptions selects cards, by search query or IDs, and the operation on them
+type BulkOptions struct {
+	Q        string
+	IDs      []string // Instead of Q
+	Op       string   // One of BulkOps
+	Tag      []string // Of tag and untag. Untag also removes tags nested under.
+	Date     time.Time
+	Days     int // Of spread
+	SRSLevel int
+}
+
+// Validate checks options of the operation, before any card is selected
+func (opts BulkOptions) Validate() error {
+	if len(opts.IDs) == 0 && strings.TrimSpace(opts.Q) == "" {
+		return errors.New("search query or card IDs is required")
+	}
+
+	switch opts.Op {
+	case "tag", "untag":
+		if len(opts.Tag) == 0 {
+			return errors.New("tag is required")
+		}
+		if opts.Op == "tag" {
+			for _, t := range opts.Tag {
+				if e := ValidateTag(t); e != nil {
+					return e
+				}
+			}
+		}
+	case "reschedule":
+		if opts.Date.IsZero() {
+			return errors.New("date is required")
+		}
+	case "spread":
+		if opts.Days < 1 {
+			return errors.New("days must be at least 1")
+		}
+	case "srsLevel":
+		if opts.SRSLevel < 0 || opts.SRSLevel >= len(srsMap) {
+			return fmt.Errorf("SRS level must be from 0 to %d", len(srsMap)-1)
+		}
+	case "suspend", "unsuspend", "reset", "delete":
+	default:
+		return fmt.Errorf("operation must be one of %s", strings.Join(BulkOps, ", "))
+	}
+
+	return nil
+}
+
+// BulkCards does an operation on all cards selected, and tells the number of cards affected.
+// Run it in a transaction, as cards are updated one by one for tags.
+func BulkCards(tx *gorm.DB, opts BulkOptions) (int64, error) {
+	if e := opts.Validate(); e != nil {
+		return 0, e
+	}
+
+	// Selected as a subquery, rather than binding every ID
+	ids := tx.Session(&gorm.Session{NewDB: true}).Model(&Card{}).Where("card.id IN ?", opts.IDs).Select("card.id")
+	if len(opts.IDs) == 0 {
+		ids = Search(tx.Session(&gorm.Session{NewDB: true}).Model(&Card{}), opts.Q).Select("card.id")
+	}
+
+	updates := func(values map[string]interface{}) (int64, error) {
+		r := tx.Model(&Card{}).Where("id IN (?)", ids).Updates(values)
+		return r.RowsAffected, r.Error
+	}
+
+	switch opts.Op {
+	case "tag", "suspend":
+		add := opts.Tag
+		if opts.Op == "suspend" {
+			add = []string{SuspendedTag}
+		}
+
+		return updateCardTags(tx, ids, func(tag map[string]bool) {
+			for _, t := range add {
+				tag[t] = true
+			}
+		})
+	case "untag", "unsuspend":
+		remove := opts.Tag
+		if opts.Op == "unsuspend" {
+			remove = []string{SuspendedTag}
+		}
+
+		return updateCardTags(tx, ids, func(tag map[string]bool) {
+			for _, parent := range remove {
+				for t := range tag {
+					if TagMatch(t, parent) {
+						delete(tag, t)
+					}
+				}
+			}
+		})
+	case "reset":
+		return updates(resetFields)
+	case "reschedule":
+		return updates(map[string]interface{}{
+			"next_review": opts.Date,
+		})
+	case "spread":
+		return spreadOverdue(tx, ids, opts.Days)
+	case "srsLevel":
+		// Due as if just answered at the level
+		return updates(map[string]interface{}{
+			"srs_level":   opts.SRSLevel,
+			"next_review": getNextReview(opts.SRSLevel),
+		})
+	case "delete":
+		// Soft-deleted with a tombstone, so that cards are not made again on load
+		return updates(map[string]interface{}{
+			"tombstone":  true,
+			"deleted_at": time.Now(),
+		})
+	}
+
+	return 0, nil
+}
+
+// updateCardTags updates tags of cards of ids, and tells the number of cards whose tags have changed
+func updateCardTags(tx *gorm.DB, ids *gorm.DB, update func(tag map[string]bool)) (int64, error) {
+	var cards []Card
+	if r := tx.Model(&Card{}).Where("id IN (?)", ids).Select("id", "tag").Find(&cards); r.Error != nil {
+		return 0, r.Error
+	}
+
+	var count int64
+
+	for _, c := range cards {
+		tag, e := c.Tag.Get()
+		if e != nil {
+			return 0, e
+		}
+
+		old := tagString(c.Tag)
+		update(tag)
+		if e := c.Tag.Set(tag); e != nil {
+			return 0, e
+		}
+		if tagString(c.Tag) == old {
+			continue
+		}
+
+		if r := tx.Model(&Card{}).Where("id = ?", c.ID).Update("tag", c.Tag); r.Error != nil {
+			return 0, r.Error
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// spreadOverdue reschedules overdue cards of ids evenly across days from now, e.g. after a vacation;
+// the most overdue first, so that some remain due now
+func spreadOverdue(tx *gorm.DB, ids *gorm.DB, days int) (int64, error) {
+	var cards []Card
+	if r := tx.
+		Where("id IN (?)", ids).
+		Where("strftime('%s', next_review) < strftime('%s', 'now')").
+		Order("next_review").
+		Select("id").
+		Find(&cards); r.Error != nil {
+		return 0, r.Error
+	}
+
+	now := time.Now()
+	for i, c := range cards {
+		day := i * days / len(cards)
+		if e := c.Reschedule(tx, now.Add(time.Duration(day)*24*time.Hour)); e != nil {
+			return 0, e
+		}
+	}
+
+	return int64(len(cards)), nil
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulkCards(t *testing.T) {
+	tx := searchFixture(t)
+
+	bulk := func(opts BulkOptions, expected int64) {
+		t.Helper()
+
+		count, e := BulkCards(tx, opts)
+		if e != nil {
+			t.Fatalf("%s: %v", opts.Op, e)
+		}
+		if count != expected {
+			t.Errorf("%s: expected %d cards, got %d", opts.Op, expected, count)
+		}
+	}
+
+	bulk(BulkOptions{Q: "develop", Op: "suspend"}, 1)
+	bulk(BulkOptions{Q: "develop", Op: "suspend"}, 0)
+	if out := searchIDs(t, tx, "is:suspended"); out != "c1" {
+		t.Errorf("expected c1 suspended, got [%s]", out)
+	}
+
+	bulk(BulkOptions{IDs: []string{"c1", "c2"}, Op: "unsuspend"}, 1)
+	if out := searchIDs(t, tx, "is:suspended"); out != "" {
+		t.Errorf("expected none suspended, got [%s]", out)
+	}
+
+	bulk(BulkOptions{IDs: []string{"c1", "c2"}, Op: "tag", Tag: []string{"zh::verb"}}, 2)
+	bulk(BulkOptions{Q: "tag:zh", Op: "untag", Tag: []string{"zh"}}, 2)
+	if out := searchIDs(t, tx, "tag:zh"); out != "" {
+		t.Errorf("expected nested tags removed, got [%s]", out)
+	}
+
+	bulk(BulkOptions{Q: "running", Op: "srsLevel", SRSLevel: 3}, 1)
+	if out := searchIDs(t, tx, "srsLevel:3 nextReview>+2d"); out != "c2" {
+		t.Errorf("expected c2 at level 3, due in 3 days, got [%s]", out)
+	}
+
+	date := time.Now().Add(-time.Hour)
+	bulk(BulkOptions{IDs: []string{"c1"}, Op: "reschedule", Date: date}, 1)
+	if out := searchIDs(t, tx, "is:due"); out != "c1" {
+		t.Errorf("expected c1 due, got [%s]", out)
+	}
+
+	bulk(BulkOptions{IDs: []string{"c1", "c2"}, Op: "reset"}, 2)
+	if out := searchIDs(t, tx, "is:new srsLevel:0"); out != "c1 c2" {
+		t.Errorf("expected all new, got [%s]", out)
+	}
+
+	bulk(BulkOptions{Q: "running", Op: "delete"}, 1)
+	if out := searchIDs(t, tx, ""); out != "c1" {
+		t.Errorf("expected c2 deleted, got [%s]", out)
+	}
+
+	for _, opts := range []BulkOptions{
+		{Op: "reset"},
+		{Q: "develop", Op: "nothing"},
+		{Q: "develop", Op: "tag"},
+		{Q: "develop", Op: "reschedule"},
+		{Q: "develop", Op: "srsLevel", SRSLevel: 100},
+	} {
+		if _, e := BulkCards(tx, opts); e == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+}
+
+func TestBulkDeleteLoad(t *testing.T) {
+	tx := testDB(t)
+	withUserDataDir(t, map[string]string{
+		"vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1) + `
+card:
+  - templateId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d02
+    noteId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03
+    front: custom
+`,
+	})
+
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	if count, e := BulkCards(tx, BulkOptions{Q: "one", Op: "delete"}); e != nil || count != 1 {
+		t.Fatalf("expected 1 card deleted, got %d %v", count, e)
+	}
+
+	// Neither by the template, nor by the override
+	s, e := Load(tx, "vocab.yaml", LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var count int64
+	tx.Model(&Card{}).Count(&count)
+	if count != 0 || s.Card.Created != 0 {
+		t.Errorf("expected the card kept deleted, got %d, %s", count, s.Card)
+	}
+}
+
+func TestSpreadOverdue(t *testing.T) {
+	tx := searchFixture(t)
+
+	c3 := Card{ID: "c3", TemplateID: "t2", NoteID: "n1"}
+	if r := tx.Create(&c3); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	for i, id := range []string{"c1", "c2", "c3"} {
+		if e := (Card{ID: id}).Reschedule(tx, time.Now().Add(-time.Duration(i+1)*24*time.Hour)); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	// Not overdue
+	if e := (Card{ID: "c1"}).Reset(tx); e != nil {
+		t.Fatal(e)
+	}
+	if out := searchIDs(t, tx, "is:new"); out != "c1" {
+		t.Errorf("expected c1 reset, got [%s]", out)
+	}
+
+	count, e := BulkCards(tx, BulkOptions{Q: "key:-", Op: "spread", Days: 2})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 overdue cards spread, got %d", count)
+	}
+
+	expected := map[string]string{
+		`nextReview<+1h`:                 "c3",
+		`nextReview>+1h nextReview<+25h`: "c2",
+		`nextReview<-1h`:                 "",
+	}
+	for q, ids := range expected {
+		if out := searchIDs(t, tx, q); out != ids {
+			t.Errorf("%s: expected [%s], got [%s]", q, ids, out)
+		}
+	}
+}
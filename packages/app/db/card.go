@@ -25,7 +25,7 @@ type Card struct {
 	Front       string
 	Back        string
 	Shared      string
-	Mnemonic    string
+	Mnemonic    string         `gorm:"index:idx_card_mnemonic,where:mnemonic != '' AND mnemonic != '{}'"`
 	SRSLevel    int            `gorm:"index"`
 	NextReview  *time.Time     `gorm:"index"`
 	LastRight   *time.Time     `gorm:"index"`
@@ -35,7 +35,8 @@ type Card struct {
 	RightStreak int            `gorm:"index"`
 	WrongStreak int            `gorm:"index"`
 	Tag         SpaceSeparated `gorm:"index"`
-	Filename    SpaceSeparated `gorm:"index"`
+	Filename    LineSeparated  `gorm:"index"` // as file paths may contain spaces
+	Tombstone   bool           // Deleted by the user, rather than pruned; so that loading doesn't restore it
 }
 
 type SpaceSeparated struct {
@@ -102,6 +103,80 @@ func (j *SpaceSeparated) Set(v map[string]bool) error {
 	return nil
 }
 
+// LineSeparated is a set of strings, which may contain spaces, but not line breaks
+type LineSeparated struct {
+	Raw string
+}
+
+func (j *LineSeparated) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal LineSeparated value:", value))
+	}
+
+	j.Raw = s
+	return nil
+}
+
+func (j LineSeparated) Value() (driver.Value, error) {
+	return j.Raw, nil
+}
+
+func (j LineSeparated) Get() (map[string]bool, error) {
+	if j.Raw == "" {
+		return map[string]bool{}, nil
+	}
+
+	if len(j.Raw) < 2 || j.Raw[0] != '\n' || j.Raw[len(j.Raw)-1] != '\n' {
+		shared.Logger.Printf("invalid LineSeparated value: %q\n", j.Raw)
+		return map[string]bool{}, nil
+	}
+
+	out := map[string]bool{}
+	for _, s := range strings.Split(j.Raw[1:len(j.Raw)-1], "\n") {
+		out[s] = true
+	}
+
+	return out, nil
+}
+
+func (j *LineSeparated) Set(v map[string]bool) error {
+	out := "\n"
+	length := 0
+	for k, t := range v {
+		if t {
+			if strings.ContainsAny(k, "\r\n") {
+				return fmt.Errorf("must not contain line breaks: %q", k)
+			}
+
+			out += k + "\n"
+			length++
+		}
+	}
+
+	if length > 0 {
+		j.Raw = out
+	} else {
+		j.Raw = ""
+	}
+
+	return nil
+}
+
+// lineLike makes SQL condition for column of LineSeparated containing a line, escaped by likeEscape
+func lineLike(column string) string {
+	return column + " LIKE '%'||char(10)||?||char(10)||'%' ESCAPE '\\'"
+}
+
+// migrateFilename converts Card.Filename of older databases, from SpaceSeparated to LineSeparated
+func migrateFilename(tx *gorm.DB) error {
+	return tx.Exec(`UPDATE card SET filename = replace(filename, ' ', char(10)) WHERE filename LIKE ' %'`).Error
+}
+
 func (Card) Tidy(tx *gorm.DB) error {
 	if r := tx.
 		Where("template_id IS NOT NULL AND note_id IS NULL").
@@ -182,3 +257,27 @@ func (c Card) UpdateSRSLevel(tx *gorm.DB, dSRSLevel int) error {
 	r := tx.Updates(&q)
 	return r.Error
 }
+
+// resetFields are scheduling fields of a new card, keeping mnemonic and tags
+var resetFields = map[string]interface{}{
+	"srs_level":    0,
+	"next_review":  nil,
+	"last_right":   nil,
+	"last_wrong":   nil,
+	"max_right":    0,
+	"max_wrong":    0,
+	"right_streak": 0,
+	"wrong_streak": 0,
+}
+
+// Reset forgets the card, returning it to new
+func (c Card) Reset(tx *gorm.DB) error {
+	r := tx.Model(&Card{}).Where("id = ?", c.ID).Updates(resetFields)
+	return r.Error
+}
+
+// Reschedule sets NextReview, keeping SRSLevel and stats
+func (c Card) Reschedule(tx *gorm.DB, nextReview time.Time) error {
+	r := tx.Model(&Card{}).Where("id = ?", c.ID).Update("next_review", nextReview)
+	return r.Error
+}
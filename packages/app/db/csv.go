@@ -0,0 +1,134 @@
+package db
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type CSVOptions struct {
+	Model     string            // Model ID or name
+	KeyColumn string            // Column of note keys, by header or 1-based index. Defaults to the first column.
+	Map       map[string]string // map[Field]Column. Defaults to all columns, by header.
+	NoHeader  bool              // The first row is data, rather than header
+}
+
+// ImportCSV loads rows of a CSV, or TSV by file extension, as notes of an existing model.
+// Notes are matched by key, so that importing again updates them.
+// f is relative to the current directory, rather than the user data directory, as a command argument.
+func ImportCSV(tx *gorm.DB, f string, csvOpts CSVOptions, opts LoadOptions) (LoadSummary, error) {
+	summary := LoadSummary{
+		File: loadFileName(f),
+	}
+
+	var model Model
+	if r := tx.Where("id = ? OR name = ?", csvOpts.Model, csvOpts.Model).First(&model); r.Error != nil {
+		if errors.Is(r.Error, gorm.ErrRecordNotFound) {
+			return summary, fmt.Errorf("no such model: %s", csvOpts.Model)
+		}
+		return summary, r.Error
+	}
+
+	file, e := os.Open(f)
+	if e != nil {
+		return summary, e
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	if strings.EqualFold(filepath.Ext(f), ".tsv") {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+
+	rows, e := reader.ReadAll()
+	if e != nil {
+		return summary, e
+	}
+
+	var header []string
+	if !csvOpts.NoHeader && len(rows) > 0 {
+		header, rows = rows[0], rows[1:]
+	}
+
+	column := func(c string) (int, error) {
+		if i, e := strconv.Atoi(c); e == nil {
+			if i < 1 {
+				return 0, fmt.Errorf("columns start from 1: %s", c)
+			}
+			return i - 1, nil
+		}
+
+		for i, h := range header {
+			if strings.TrimSpace(h) == c {
+				return i, nil
+			}
+		}
+
+		return 0, fmt.Errorf("no such column: %s", c)
+	}
+
+	keyColumn := 0
+	if csvOpts.KeyColumn != "" {
+		if keyColumn, e = column(csvOpts.KeyColumn); e != nil {
+			return summary, e
+		}
+	}
+
+	fields := make(map[string]int)
+	if len(csvOpts.Map) > 0 {
+		for k, c := range csvOpts.Map {
+			if fields[k], e = column(c); e != nil {
+				return summary, e
+			}
+		}
+
+		if keyColumn < len(header) {
+			h := strings.TrimSpace(header[keyColumn])
+			if _, ok := fields[h]; !ok && h != "" {
+				fields[h] = keyColumn
+			}
+		}
+	} else {
+		if header == nil {
+			return summary, errors.New("map of fields to columns is required without header")
+		}
+
+		for i, h := range header {
+			if h = strings.TrimSpace(h); h != "" {
+				fields[h] = i
+			}
+		}
+	}
+
+	loadFile := LoadedStruct{}
+	for i, row := range rows {
+		if keyColumn >= len(row) || strings.TrimSpace(row[keyColumn]) == "" {
+			return summary, fmt.Errorf("row %d: no key", i+1)
+		}
+		key := strings.TrimSpace(row[keyColumn])
+
+		data := make(map[string]interface{})
+		for k, c := range fields {
+			if c < len(row) && row[c] != "" {
+				data[k] = row[c]
+			}
+		}
+
+		// ID is resolved from key, see ResolveRefs
+		loadFile.Note = append(loadFile.Note, LoadedNoteStruct{
+			Key:     key,
+			ModelID: model.ID,
+			Data:    data,
+		})
+	}
+
+	return LoadFrom(tx, loadFileName(f), loadFile, opts)
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	tx := testDB(t)
+	withUserDataDir(t, map[string]string{
+		"vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1),
+	})
+
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	tsv := filepath.Join(t.TempDir(), "words.tsv")
+	if e := os.WriteFile(tsv, []byte("key\tword\tmeaning\nvocab-1\tone\t1\nvocab-2\ttwo\t2\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	csvOpts := CSVOptions{
+		Model:     "vocab",
+		KeyColumn: "key",
+		Map: map[string]string{
+			"word":    "2",
+			"meaning": "meaning",
+		},
+	}
+
+	s, e := ImportCSV(tx, tsv, csvOpts, LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// vocab-1 is the note loaded from YAML, matched by key
+	if s.Note != (LoadCount{Created: 1, Updated: 1}) {
+		t.Errorf("expected 1 note created and 1 updated, got %s", s.Note)
+	}
+
+	var n Note
+	if r := tx.Preload("Attrs").Where("key = ?", "vocab-2").First(&n); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	data := make([]string, 0)
+	for _, a := range n.Attrs {
+		data = append(data, a.Key+"="+a.Value.Raw)
+	}
+	if r := strings.Join(data, ","); len(n.Attrs) != 3 || !strings.Contains(r, "word=two") || !strings.Contains(r, "meaning=2") {
+		t.Errorf("expected key, word and meaning, got [%s]", r)
+	}
+
+	s, e = ImportCSV(tx, tsv, csvOpts, LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.Note != (LoadCount{Unchanged: 2}) {
+		t.Errorf("expected importing again to leave notes unchanged, got %s", s.Note)
+	}
+}
+
+func TestImportCSVFilename(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"vocab.yaml":     strings.Replace(loadFixture, "%s", "one", 1),
+		"deck/words.csv": "key,word\nvocab-2,two\n",
+	})
+
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	// Relative to the current directory, as a command argument
+	wd, e := os.Getwd()
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+	if e := os.Chdir(filepath.Join(dir, "deck")); e != nil {
+		t.Fatal(e)
+	}
+
+	if _, e := ImportCSV(tx, "words.csv", CSVOptions{Model: "vocab", KeyColumn: "key"}, LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	var c Card
+	if r := tx.Where(lineLike("filename"), likeEscape(filepath.Join("deck", "words.csv"))).First(&c); r.Error != nil {
+		t.Errorf("expected the file name relative to the user data directory, got %v", r.Error)
+	}
+}
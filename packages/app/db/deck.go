@@ -0,0 +1,251 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/rep2recall/r2r/shared"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// SavedSearch is a named study set (filtered deck), usable as `deck:name` in search
+type SavedSearch struct {
+	ID        uint
+	CreatedAt time.Time
+	UpdatedAt time.Time `gorm:"index"`
+
+	Name         string `gorm:"index:,unique"`
+	Query        string
+	Files        StringList
+	State        StringList
+	SessionLimit int    // Max number of cards per quiz session, 0 for unlimited
+	SessionOrder string // Order of cards in a quiz session (random / due / created)
+}
+
+type StringList []string
+
+// Scan scan value into JSON, implements sql.Scanner interface
+func (j *StringList) Scan(value interface{}) error {
+	if value == nil {
+		*j = nil
+		return nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal StringList value:", value))
+	}
+
+	r := make([]string, 0)
+	err := json.Unmarshal([]byte(s), &r)
+	*j = r
+	return err
+}
+
+// Value return json value, implement driver.Value interface
+func (j StringList) Value() (driver.Value, error) {
+	if j == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// GormDBDataType represents driver's JSON data type
+func (StringList) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	return "JSON"
+}
+
+// GormDataType gorm common data type
+func (StringList) GormDataType() string {
+	return "StringList"
+}
+
+// Filter selects cards by search query, loaded files, card state and saved search
+type Filter struct {
+	Q     string
+	Files []string
+	State []string
+	Deck  string
+}
+
+func (s SavedSearch) Filter() Filter {
+	return Filter{
+		Q:     s.Query,
+		Files: s.Files,
+		State: s.State,
+	}
+}
+
+// Arrange orders cards for a quiz session, then applies the limit
+func (s SavedSearch) Arrange(cards []Card) []Card {
+	switch s.SessionOrder {
+	case "due":
+		sort.SliceStable(cards, func(i, j int) bool {
+			if cards[i].NextReview == nil || cards[j].NextReview == nil {
+				return cards[i].NextReview == nil && cards[j].NextReview != nil
+			}
+			return cards[i].NextReview.Before(*cards[j].NextReview)
+		})
+	case "created":
+		sort.SliceStable(cards, func(i, j int) bool {
+			return cards[i].CreatedAt.Before(cards[j].CreatedAt)
+		})
+	default:
+		rand.Seed(time.Now().UnixNano())
+		rand.Shuffle(len(cards), func(i, j int) {
+			cards[i], cards[j] = cards[j], cards[i]
+		})
+	}
+
+	if s.SessionLimit > 0 && len(cards) > s.SessionLimit {
+		cards = cards[:s.SessionLimit]
+	}
+
+	return cards
+}
+
+// Apply adds the conditions of Filter to tx
+func (f Filter) Apply(tx *gorm.DB) (*gorm.DB, error) {
+	return f.apply(tx, map[string]bool{})
+}
+
+func (f Filter) apply(tx *gorm.DB, decks map[string]bool) (*gorm.DB, error) {
+	rootTx := tx
+
+	for _, file := range f.Files {
+		str, e := LoadStruct(file)
+		if e != nil {
+			return nil, e
+		}
+
+		if e := ResolveRefs(tx, &str); e != nil {
+			return nil, e
+		}
+
+		var cond *gorm.DB
+
+		noteIDs := make([]string, 0)
+		for _, n := range str.Note {
+			noteIDs = append(noteIDs, n.ID)
+		}
+
+		if len(noteIDs) > 0 {
+			cond = tx.Where("card.note_id IN ?", noteIDs)
+		}
+
+		cardIDs := make([]string, 0)
+		for _, c := range str.Card {
+			cardIDs = append(cardIDs, c.ID)
+		}
+
+		if len(cardIDs) > 0 {
+			if cond != nil {
+				cond = cond.Or(tx.Where("card.id IN ?", cardIDs))
+			} else {
+				cond = tx.Where("card.id IN ?", cardIDs)
+			}
+		}
+
+		if cond != nil {
+			rootTx = rootTx.Where(cond)
+		}
+	}
+
+	if f.Deck != "" {
+		sub, e := deckCards(tx, f.Deck, decks)
+		if e != nil {
+			return nil, e
+		}
+
+		rootTx = rootTx.Where("card.id IN (?)", sub)
+	}
+
+	rTx := search(rootTx, f.Q, decks)
+
+	if len(f.State) > 0 {
+		rState := tx.Where("FALSE")
+
+		for _, s := range f.State {
+			switch s {
+			case "new":
+				rState = rState.Or("card.next_review IS NULL")
+			case "learning":
+				rState = rState.Or("card.srs_level <= 3")
+			case "graduated":
+				rState = rState.Or("card.srs_level > 3")
+			case "leech":
+				rState = rState.Or("card.wrong_streak > 1")
+			}
+		}
+
+		for _, s := range f.State {
+			switch s {
+			case "due":
+				rState = rState.Where("strftime('%s', card.next_review) < strftime('%s', 'now')")
+			}
+		}
+
+		rTx = rTx.Where(rState)
+	}
+
+	return rTx, nil
+}
+
+// deckCards makes a subquery of card IDs in the saved search, erroring on saved searches already visited
+func deckCards(tx *gorm.DB, name string, decks map[string]bool) (*gorm.DB, error) {
+	if decks[name] {
+		return nil, fmt.Errorf("circular saved search: %s", name)
+	}
+
+	newTx := tx.Session(&gorm.Session{NewDB: true})
+
+	var deck SavedSearch
+	if r := newTx.Where("name = ?", name).First(&deck); r.Error != nil {
+		return nil, r.Error
+	}
+
+	visited := map[string]bool{name: true}
+	for k := range decks {
+		visited[k] = true
+	}
+
+	sub, e := deck.Filter().apply(newTx.Model(&Card{}), visited)
+	if e != nil {
+		return nil, e
+	}
+
+	return sub.Select("card.id"), nil
+}
+
+// SyncConfigDecks saves decks declared in config.yaml, overwriting saved searches of the same name
+func SyncConfigDecks(tx *gorm.DB) error {
+	for name, d := range shared.Config.Deck {
+		if r := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_at", "query", "files", "state", "session_limit", "session_order"}),
+		}).Create(&SavedSearch{
+			Name:         name,
+			Query:        d.Query,
+			Files:        d.Files,
+			State:        d.State,
+			SessionLimit: d.Limit,
+			SessionOrder: d.Order,
+		}); r.Error != nil {
+			return r.Error
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+type ExportOptions struct {
+	State    bool   // Include scheduling state, as the state section
+	MediaDir string // Copy media of notes into MediaDir, relative to Dir; otherwise, notes keep MediaURL in data
+	Dir      string // Directory of the exported file
+}
+
+// Export makes cards matching filter, with their notes, models and templates, into the format of Load
+func Export(tx *gorm.DB, filter Filter, opts ExportOptions) (LoadedStruct, error) {
+	out := LoadedStruct{}
+
+	rTx, e := filter.Apply(tx.Model(&Card{}))
+	if e != nil {
+		return out, e
+	}
+
+	var cards []Card
+	if r := rTx.Find(&cards); r.Error != nil {
+		return out, r.Error
+	}
+
+	sort.Slice(cards, func(i, j int) bool {
+		if cards[i].NoteID != cards[j].NoteID {
+			return cards[i].NoteID < cards[j].NoteID
+		}
+		return cards[i].TemplateID < cards[j].TemplateID
+	})
+
+	noteIDs := make([]string, 0)
+	noteMap := make(map[string]bool)
+	for _, c := range cards {
+		if !noteMap[c.NoteID] {
+			noteMap[c.NoteID] = true
+			noteIDs = append(noteIDs, c.NoteID)
+		}
+	}
+
+	var notes []Note
+	if r := tx.Preload("Attrs").Where("id IN ?", noteIDs).Order("id").Find(&notes); r.Error != nil {
+		return out, r.Error
+	}
+
+	modelIDs := make([]string, 0)
+	modelMap := make(map[string]bool)
+	for _, n := range notes {
+		if !modelMap[n.ModelID] {
+			modelMap[n.ModelID] = true
+			modelIDs = append(modelIDs, n.ModelID)
+		}
+	}
+
+	var models []Model
+	if r := tx.Where("id IN ?", modelIDs).Order("id").Find(&models); r.Error != nil {
+		return out, r.Error
+	}
+
+	for _, m := range models {
+		out.Model = append(out.Model, m.Loaded())
+	}
+
+	var templates []Template
+	if r := tx.Where("model_id IN ?", modelIDs).Order("id").Find(&templates); r.Error != nil {
+		return out, r.Error
+	}
+
+	for _, t := range templates {
+		out.Template = append(out.Template, t.Loaded())
+	}
+
+	mediaNames := make(map[string]string)
+	for _, n := range notes {
+		note, e := n.Loaded()
+		if e != nil {
+			return out, e
+		}
+
+		if opts.MediaDir != "" {
+			if e := exportMedia(tx, &note, opts, mediaNames); e != nil {
+				return out, e
+			}
+		}
+
+		out.Note = append(out.Note, note)
+	}
+
+	for _, c := range cards {
+		card, e := c.Loaded()
+		if e != nil {
+			return out, e
+		}
+
+		// Cards compiled from templates are made again on load, unless they are overridden or tagged
+		if c.Front != "" || c.Back != "" || c.Shared != "" || len(card.Tag) > 0 {
+			out.Card = append(out.Card, card)
+		}
+
+		if opts.State {
+			state, e := c.State()
+			if e != nil {
+				return out, e
+			}
+			out.State = append(out.State, state)
+		}
+	}
+
+	return out, nil
+}
+
+// tagList is SpaceSeparated as a sorted list, or nil if empty
+func tagList(s SpaceSeparated) ([]string, error) {
+	set, e := s.Get()
+	if e != nil {
+		return nil, e
+	}
+
+	var out []string
+	for t, v := range set {
+		if v {
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+
+	return out, nil
+}
+
+// Loaded is m in the format of Load
+func (m Model) Loaded() LoadedModelStruct {
+	var lang map[string]string
+	if m.Lang != nil {
+		lang = make(map[string]string)
+		for k, v := range m.Lang {
+			if s, ok := v.(string); ok {
+				lang[k] = s
+			}
+		}
+	}
+
+	return LoadedModelStruct{
+		ID:        m.ID,
+		Name:      m.Name,
+		Front:     m.Front,
+		Back:      m.Back,
+		Shared:    m.Shared,
+		Generator: m.Generator,
+		Lang:      lang,
+	}
+}
+
+// Loaded is t in the format of Load
+func (t Template) Loaded() LoadedTemplateStruct {
+	return LoadedTemplateStruct{
+		ID:      t.ID,
+		ModelID: t.ModelID,
+		Name:    t.Name,
+		Front:   t.Front,
+		Back:    t.Back,
+		Shared:  t.Shared,
+		If:      t.If,
+	}
+}
+
+// Loaded is n in the format of Load, with n.Attrs preloaded
+func (n Note) Loaded() (LoadedNoteStruct, error) {
+	data := make(map[string]interface{})
+	for _, a := range n.Attrs {
+		v, e := a.Value.Get()
+		if e != nil {
+			return LoadedNoteStruct{}, e
+		}
+		data[a.Key] = v
+	}
+
+	tag, e := tagList(n.Tag)
+	if e != nil {
+		return LoadedNoteStruct{}, e
+	}
+
+	return LoadedNoteStruct{
+		Key:     n.Key,
+		ID:      n.ID,
+		ModelID: n.ModelID,
+		Data:    data,
+		Tag:     tag,
+	}, nil
+}
+
+// Loaded is c in the format of Load, as an override
+func (c Card) Loaded() (LoadedCardStruct, error) {
+	tag, e := tagList(c.Tag)
+	if e != nil {
+		return LoadedCardStruct{}, e
+	}
+
+	return LoadedCardStruct{
+		ID:         c.ID,
+		TemplateID: c.TemplateID,
+		NoteID:     c.NoteID,
+		Tag:        tag,
+		Front:      c.Front,
+		Back:       c.Back,
+		Shared:     c.Shared,
+	}, nil
+}
+
+// State is the scheduling state of c, in the format of Load
+func (c Card) State() (LoadedStateStruct, error) {
+	tag, e := tagList(c.Tag)
+	if e != nil {
+		return LoadedStateStruct{}, e
+	}
+
+	return LoadedStateStruct{
+		TemplateID:  c.TemplateID,
+		NoteID:      c.NoteID,
+		Tag:         tag,
+		Mnemonic:    c.Mnemonic,
+		SRSLevel:    c.SRSLevel,
+		NextReview:  c.NextReview,
+		LastRight:   c.LastRight,
+		LastWrong:   c.LastWrong,
+		MaxRight:    c.MaxRight,
+		MaxWrong:    c.MaxWrong,
+		RightStreak: c.RightStreak,
+		WrongStreak: c.WrongStreak,
+	}, nil
+}
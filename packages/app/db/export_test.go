@@ -0,0 +1,184 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1),
+	})
+
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	nextReview := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	if r := tx.Model(&Card{}).Where("TRUE").Updates(map[string]interface{}{
+		"srs_level":   3,
+		"next_review": nextReview,
+		"mnemonic":    "won",
+		"tag":         " verb ",
+	}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	out, e := Export(tx, Filter{Q: "word:one"}, ExportOptions{State: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(out.Model) != 1 || len(out.Template) != 1 || len(out.Note) != 1 || len(out.State) != 1 {
+		t.Fatalf("expected 1 of each, got %+v", out)
+	}
+	if len(out.Card) != 1 || strings.Join(out.Card[0].Tag, " ") != "verb" || out.Card[0].Front != "" {
+		t.Errorf("expected the card of its tag only, got %+v", out.Card)
+	}
+
+	for _, f := range []string{"export.yaml", "export.json", "export.toml"} {
+		b, e := out.Marshal(FileFormat(f))
+		if e != nil {
+			t.Fatal(e)
+		}
+		if e := os.WriteFile(filepath.Join(dir, f), b, 0644); e != nil {
+			t.Fatal(e)
+		}
+
+		// Round trip into another database
+		tx2 := testDB(t)
+		if _, e := Load(tx2, f, LoadOptions{}); e != nil {
+			t.Fatalf("%s: %v", f, e)
+		}
+
+		var c Card
+		if r := tx2.First(&c); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+
+		if c.SRSLevel != 3 || c.Mnemonic != "won" || c.NextReview == nil || !c.NextReview.Equal(nextReview) || tagString(c.Tag) != "verb" {
+			t.Errorf("%s: expected state to be restored, got %+v", f, c)
+		}
+	}
+
+	// Tags of cards, without state
+	noState, e := Export(tx, Filter{Q: "word:one"}, ExportOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	b, e := noState.Marshal("yaml")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(filepath.Join(dir, "no-state.yaml"), b, 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	tx3 := testDB(t)
+	if _, e := Load(tx3, "no-state.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	var c Card
+	if r := tx3.First(&c); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if c.SRSLevel != 0 || tagString(c.Tag) != "verb" {
+		t.Errorf("expected the tag, without state, got %+v", c)
+	}
+
+	// Notes only, a note per line
+	b, e = out.Marshal("jsonl")
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := `{"key":"vocab-1","id":"7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03","modelId":"7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01","data":{"word":"one"}}` + "\n"
+	if string(b) != expected {
+		t.Errorf("expected JSONL [%s], got [%s]", expected, b)
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	tx := testDB(t)
+	withUserDataDir(t, map[string]string{
+		"vocab.json": `{
+  "model": [{"name": "vocab"}],
+  "template": [{"model": "vocab", "name": "forward", "front": "<%= it.word %>"}]
+}`,
+		"escaped.json": `{
+  "note": [{"key": "vocab-4", "model": "vocab", "data": {"word": "<b>four<\/b> \u00e9\ud83d\ude00", "yes": true}}]
+}`,
+		"notes.jsonl": `{"key": "vocab-1", "model": "vocab", "data": {"word": "one"}}
+
+{"key": "vocab-2", "model": "vocab", "data": {"word": "two"}}
+`,
+		"more.toml": `
+[[note]]
+key = "vocab-3"
+model = "vocab"
+data = { word = "three" }
+`,
+		"invalid.jsonl": `{"key": "vocab-1", "model": "vocab", "data": {}}
+{"key": "vocab-2", "model": "nonexistent", "data": {}}
+`,
+		"invalid.json": `{
+  "note": [
+    {"key": "x", "model": "nonexistent", "data": {}}
+  ]
+}`,
+		"broken.json": `{
+  "note": [}
+}`,
+		"invalid.toml": `
+[[note]]
+key =
+`,
+	})
+
+	summaries, e := LoadFiles(tx, []string{"vocab.json", "notes.jsonl", "more.toml", "escaped.json"}, LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var count int64
+	tx.Model(&Note{}).Count(&count)
+	if len(summaries) != 4 || count != 4 {
+		t.Errorf("expected 4 notes of 4 files, got %d of %d", count, len(summaries))
+	}
+
+	escaped, e := LoadStruct("escaped.json")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if data := escaped.Note[0].Data; data["word"] != "<b>four</b> é😀" || data["yes"] != true {
+		t.Errorf("expected JSON escapes decoded, got %v", data)
+	}
+
+	_, e = LoadFiles(tx, []string{"invalid.jsonl"}, LoadOptions{})
+	expected := "invalid.jsonl:2:1: note[1].model: no such model: nonexistent"
+	if e == nil || !strings.HasPrefix(e.Error(), "invalid.jsonl:2:") || !strings.HasSuffix(e.Error(), "note[1].model: no such model: nonexistent") {
+		t.Errorf("expected [%s], got [%v]", expected, e)
+	}
+
+	_, e = LoadFiles(tx, []string{"invalid.json"}, LoadOptions{})
+	expected = "invalid.json:3:27: note[0].model: no such model: nonexistent"
+	if e == nil || e.Error() != expected {
+		t.Errorf("expected [%s], got [%v]", expected, e)
+	}
+
+	_, e = LoadStruct("broken.json")
+	var errs LoadErrors
+	if !errors.As(e, &errs) || errs[0].Line != 2 {
+		t.Errorf("expected error on line 2, got [%v]", e)
+	}
+
+	_, e = LoadStruct("invalid.toml")
+	if !errors.As(e, &errs) || errs[0].Line == 0 || strings.HasPrefix(errs[0].Message, "toml:") {
+		t.Errorf("expected error with line number, got [%v]", e)
+	}
+}
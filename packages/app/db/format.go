@@ -0,0 +1,371 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// loadFormats are formats of files to load, by extension
+var loadFormats = map[string]string{
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".json":  "json",
+	".jsonl": "jsonl", // A note per line
+	".toml":  "toml",
+}
+
+var tomlLineRegex = regexp.MustCompile(`^toml: line \d+(?: \(last key .*?\))?: `)
+
+// FileFormat is the format of f by extension, or YAML by default
+func FileFormat(f string) string {
+	if format, ok := loadFormats[strings.ToLower(filepath.Ext(f))]; ok {
+		return format
+	}
+	return "yaml"
+}
+
+// isLoadFile tells whether f is loaded, when in a directory to load
+func isLoadFile(f string) bool {
+	_, ok := loadFormats[strings.ToLower(filepath.Ext(f))]
+	return ok
+}
+
+// documents parses b, in the format of f, into YAML nodes;
+// so that all formats are decoded, and positioned in LoadError, alike
+func documents(f string, b []byte) ([]*yaml.Node, error) {
+	out := make([]*yaml.Node, 0)
+
+	switch FileFormat(f) {
+	case "jsonl":
+		seq := &yaml.Node{
+			Kind: yaml.SequenceNode,
+			Tag:  "!!seq",
+		}
+
+		for i, line := range strings.Split(string(b), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			nodes, e := jsonNodes(f, []byte(line))
+			if e != nil {
+				return nil, e
+			}
+
+			for _, node := range nodes {
+				shiftLines(node, i)
+				seq.Content = append(seq.Content, node.Content...)
+			}
+		}
+
+		out = append(out, &yaml.Node{
+			Kind: yaml.DocumentNode,
+			Content: []*yaml.Node{{
+				Kind: yaml.MappingNode,
+				Tag:  "!!map",
+				Content: []*yaml.Node{
+					{Kind: yaml.ScalarNode, Tag: "!!str", Value: "note"},
+					seq,
+				},
+			}},
+		})
+	case "toml":
+		var v map[string]interface{}
+		if _, e := toml.Decode(string(b), &v); e != nil {
+			var perr toml.ParseError
+			if errors.As(e, &perr) {
+				return nil, LoadErrors{{
+					File:    f,
+					Line:    perr.Position.Line,
+					Message: tomlLineRegex.ReplaceAllString(perr.Error(), ""),
+				}}
+			}
+			return nil, e
+		}
+
+		// Without line numbers
+		var node yaml.Node
+		if e := node.Encode(v); e != nil {
+			return nil, e
+		}
+		out = append(out, &node)
+	case "json":
+		nodes, e := jsonNodes(f, b)
+		if e != nil {
+			return nil, e
+		}
+		out = append(out, nodes...)
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		for {
+			var node yaml.Node
+			if e := dec.Decode(&node); e != nil {
+				if errors.Is(e, io.EOF) {
+					break
+				}
+				return nil, yamlErrors(f, e)
+			}
+			out = append(out, &node)
+		}
+	}
+
+	return out, nil
+}
+
+// jsonNodes parses b as JSON values, into YAML documents with lines and columns.
+// JSON is mostly YAML, but not the escapes, like \/, that YAML does not know.
+func jsonNodes(f string, b []byte) ([]*yaml.Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	out := make([]*yaml.Node, 0)
+	for {
+		node, e := jsonNode(dec, b)
+		if e != nil {
+			if errors.Is(e, io.EOF) {
+				break
+			}
+
+			offset := dec.InputOffset()
+			var serr *json.SyntaxError
+			if errors.As(e, &serr) {
+				offset = serr.Offset
+			}
+			line, _ := jsonPosition(b, offset)
+			return nil, LoadErrors{{
+				File:    f,
+				Line:    line,
+				Message: e.Error(),
+			}}
+		}
+
+		out = append(out, &yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Line:    node.Line,
+			Column:  node.Column,
+			Content: []*yaml.Node{node},
+		})
+	}
+
+	return out, nil
+}
+
+// jsonNode reads the next value of dec, which reads b, as a YAML node
+func jsonNode(dec *json.Decoder, b []byte) (*yaml.Node, error) {
+	// The token starts after whitespaces and separators, from the last token
+	offset := dec.InputOffset()
+	for offset < int64(len(b)) && strings.IndexByte(" \t\r\n,:", b[offset]) >= 0 {
+		offset++
+	}
+
+	tok, e := dec.Token()
+	if e != nil {
+		return nil, e
+	}
+
+	node := &yaml.Node{}
+	node.Line, node.Column = jsonPosition(b, offset)
+
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			node.Kind = yaml.MappingNode
+			node.Tag = "!!map"
+			for dec.More() {
+				k, e := jsonNode(dec, b)
+				if e != nil {
+					return nil, e
+				}
+				val, e := jsonNode(dec, b)
+				if e != nil {
+					return nil, e
+				}
+				node.Content = append(node.Content, k, val)
+			}
+		case '[':
+			node.Kind = yaml.SequenceNode
+			node.Tag = "!!seq"
+			for dec.More() {
+				c, e := jsonNode(dec, b)
+				if e != nil {
+					return nil, e
+				}
+				node.Content = append(node.Content, c)
+			}
+		default:
+			return nil, &json.SyntaxError{Offset: offset}
+		}
+
+		// The closing delimiter
+		if _, e := dec.Token(); e != nil {
+			return nil, e
+		}
+	case string:
+		node.Kind = yaml.ScalarNode
+		node.Tag = "!!str"
+		node.Value = v
+	case json.Number:
+		node.Kind = yaml.ScalarNode
+		node.Tag = "!!float"
+		if _, e := v.Int64(); e == nil {
+			node.Tag = "!!int"
+		}
+		node.Value = v.String()
+	case bool:
+		node.Kind = yaml.ScalarNode
+		node.Tag = "!!bool"
+		node.Value = fmt.Sprint(v)
+	case nil:
+		node.Kind = yaml.ScalarNode
+		node.Tag = "!!null"
+		node.Value = "null"
+	}
+
+	return node, nil
+}
+
+// jsonPosition is the line and column, from 1, of offset in b
+func jsonPosition(b []byte, offset int64) (int, int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+
+	before := b[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len([]rune(string(before[bytes.LastIndexByte(before, '\n')+1:]))) + 1
+	return line, column
+}
+
+func shiftLines(node *yaml.Node, n int) {
+	node.Line += n
+	for _, c := range node.Content {
+		shiftLines(c, n)
+	}
+}
+
+// Marshal writes l in format, see FileFormat. As JSONL, only notes are written.
+func (l LoadedStruct) Marshal(format string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch format {
+	case "json":
+		var node yaml.Node
+		if e := node.Encode(l); e != nil {
+			return nil, e
+		}
+
+		var compact bytes.Buffer
+		if e := writeJSON(&compact, &node); e != nil {
+			return nil, e
+		}
+		if e := json.Indent(&buf, compact.Bytes(), "", "  "); e != nil {
+			return nil, e
+		}
+		buf.WriteString("\n")
+	case "jsonl":
+		for _, n := range l.Note {
+			var node yaml.Node
+			if e := node.Encode(n); e != nil {
+				return nil, e
+			}
+			if e := writeJSON(&buf, &node); e != nil {
+				return nil, e
+			}
+			buf.WriteString("\n")
+		}
+	case "toml":
+		var node yaml.Node
+		if e := node.Encode(l); e != nil {
+			return nil, e
+		}
+
+		var v map[string]interface{}
+		if e := node.Decode(&v); e != nil {
+			return nil, e
+		}
+
+		if e := toml.NewEncoder(&buf).Encode(v); e != nil {
+			return nil, e
+		}
+	case "yaml":
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if e := enc.Encode(l); e != nil {
+			return nil, e
+		}
+		if e := enc.Close(); e != nil {
+			return nil, e
+		}
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeJSON writes node as JSON, keeping the order of keys
+func writeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeJSON(buf, node.Content[0])
+	case yaml.AliasNode:
+		return writeJSON(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteString("{")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+
+			k, e := json.Marshal(node.Content[i].Value)
+			if e != nil {
+				return e
+			}
+			buf.Write(k)
+			buf.WriteString(":")
+
+			if e := writeJSON(buf, node.Content[i+1]); e != nil {
+				return e
+			}
+		}
+		buf.WriteString("}")
+	case yaml.SequenceNode:
+		buf.WriteString("[")
+		for i, c := range node.Content {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			if e := writeJSON(buf, c); e != nil {
+				return e
+			}
+		}
+		buf.WriteString("]")
+	default:
+		var v interface{}
+		if e := node.Decode(&v); e != nil {
+			return e
+		}
+
+		b, e := json.Marshal(v)
+		if e != nil {
+			return e
+		}
+		buf.Write(b)
+	}
+
+	return nil
+}
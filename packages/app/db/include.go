@@ -0,0 +1,203 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/rep2recall/r2r/shared"
+	"gopkg.in/yaml.v3"
+)
+
+// loadOrigin is where an entry is, in included files and documents, for LoadError
+type loadOrigin struct {
+	file string
+	node *yaml.Node // Document
+	path string     // In the document, e.g. `note[3]`
+}
+
+// loadDocuments reads all documents of f, in any format of FileFormat, after the files they include, merged in order.
+// Files are read once, even if included more than once; and include cycles are reported.
+func loadDocuments(f string, stack []string, seen map[string]bool) (LoadedStruct, error) {
+	out := LoadedStruct{
+		origins: make(map[string]loadOrigin),
+	}
+
+	path := userDataPath(f)
+	seen[path] = true
+	stack = append(stack, f)
+
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return out, e
+	}
+
+	nodes, e := documents(f, b)
+	if e != nil {
+		return out, e
+	}
+
+	for _, node := range nodes {
+		var doc LoadedStruct
+		if e := node.Decode(&doc); e != nil {
+			return out, yamlErrors(f, e)
+		}
+		doc.file = f
+		doc.node = node
+
+		if out.node == nil {
+			out.node = doc.node
+		}
+
+		var errs LoadErrors
+		for i, inc := range doc.Include {
+			incFile := inc
+			if !filepath.IsAbs(incFile) {
+				incFile = filepath.Join(filepath.Dir(f), incFile)
+			}
+
+			isCycle := false
+			for _, s := range stack {
+				if userDataPath(s) == userDataPath(incFile) {
+					isCycle = true
+				}
+			}
+			if isCycle {
+				errs = append(errs, doc.errorAt(
+					fmt.Sprintf("include[%d]", i),
+					"include cycle: "+strings.Join(append(stack, incFile), " -> "),
+				))
+				continue
+			}
+
+			if seen[userDataPath(incFile)] {
+				continue
+			}
+
+			included, e := loadDocuments(incFile, stack, seen)
+			if e != nil {
+				var lerrs LoadErrors
+				if errors.As(e, &lerrs) {
+					errs = append(errs, lerrs...)
+					continue
+				}
+				errs = append(errs, doc.errorAt(fmt.Sprintf("include[%d]", i), e.Error()))
+				continue
+			}
+
+			out.merge(included)
+		}
+		if len(errs) > 0 {
+			return out, errs
+		}
+
+		out.merge(doc)
+	}
+
+	return out, nil
+}
+
+// includedFiles are the paths of files included by f, recursively, as userDataPath.
+// Files that fail to load are still listed, as far as read, for Watcher to load f again when fixed.
+func includedFiles(f string) []string {
+	seen := make(map[string]bool)
+	loadDocuments(f, nil, seen)
+
+	out := make([]string, 0)
+	for p := range seen {
+		if p != userDataPath(f) {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+// userDataPath is the absolute path of f, relative to the user data directory
+func userDataPath(f string) string {
+	if !filepath.IsAbs(f) {
+		f = filepath.Join(shared.UserDataDir, f)
+	}
+	return filepath.Clean(f)
+}
+
+// merge appends entries of other, keeping where they are from
+func (l *LoadedStruct) merge(other LoadedStruct) {
+	origin := func(kind string, i int) loadOrigin {
+		p := fmt.Sprintf("%s[%d]", kind, i)
+		if o, ok := other.origins[p]; ok {
+			return o
+		}
+		return loadOrigin{
+			file: other.file,
+			node: other.node,
+			path: p,
+		}
+	}
+
+	for i, m := range other.Model {
+		l.origins[fmt.Sprintf("model[%d]", len(l.Model))] = origin("model", i)
+		l.Model = append(l.Model, m)
+	}
+	for i, t := range other.Template {
+		l.origins[fmt.Sprintf("template[%d]", len(l.Template))] = origin("template", i)
+		l.Template = append(l.Template, t)
+	}
+	for i, n := range other.Note {
+		l.origins[fmt.Sprintf("note[%d]", len(l.Note))] = origin("note", i)
+		l.Note = append(l.Note, n)
+	}
+	for i, c := range other.Card {
+		l.origins[fmt.Sprintf("card[%d]", len(l.Card))] = origin("card", i)
+		l.Card = append(l.Card, c)
+	}
+	for i, st := range other.State {
+		l.origins[fmt.Sprintf("state[%d]", len(l.State))] = origin("state", i)
+		l.State = append(l.State, st)
+	}
+
+	// Later snippets, e.g. of the including file, override
+	for k, v := range other.Snippet {
+		if l.Snippet == nil {
+			l.Snippet = make(map[string]string)
+		}
+		l.Snippet[k] = v
+	}
+}
+
+// resolveSnippets prepends snippets used by models to their shared
+func (l *LoadedStruct) resolveSnippets() error {
+	var errs LoadErrors
+
+	for i, m := range l.Model {
+		if len(m.Use) == 0 {
+			continue
+		}
+
+		parts := make([]string, 0)
+		for j, name := range m.Use {
+			snippet, ok := l.Snippet[name]
+			if !ok {
+				errs = append(errs, l.errorAt(fmt.Sprintf("model[%d].use[%d]", i, j), "no such snippet: "+name))
+				continue
+			}
+			parts = append(parts, strings.TrimRight(snippet, "\n"))
+		}
+
+		if m.Shared != "" {
+			parts = append(parts, m.Shared)
+		}
+
+		m.Shared = strings.Join(parts, "\n")
+		m.Use = nil
+		l.Model[i] = m
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
@@ -2,34 +2,19 @@ package db
 
 import (
 	"database/sql"
-	"os"
-	"os/exec"
 	"path/filepath"
 
 	"github.com/mattn/go-sqlite3"
+	"github.com/rep2recall/r2r/segmenter"
 	"github.com/rep2recall/r2r/shared"
 	gormSqlite "gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
 )
 
-func tokenize(s string, lang string) string {
-	segmenter := shared.Config.Segmenter[lang]
-	if len(segmenter.Command) > 0 {
-		args := segmenter.Command[1:]
-		args = append(args, s)
-
-		dir := filepath.Join(shared.UserDataDir, "plugins", "app")
-		cmd := exec.Command(filepath.Join(dir, segmenter.Command[0]), args...)
-		cmd.Dir = dir
-		cmd.Stderr = os.Stderr
-
-		if b, e := cmd.Output(); e == nil {
-			return string(b)
-		}
-	}
-
-	return s
+// tokenize errors abort the write, rather than indexing tokens that cannot be deleted
+func tokenize(s string, lang string) (string, error) {
+	return segmenter.Tokenize(s, lang)
 }
 
 func init() {
@@ -44,16 +29,34 @@ func init() {
 }
 
 func Connect() *gorm.DB {
+	db, err := open(filepath.Join(shared.UserDataDir, shared.Config.DB))
+	if err != nil {
+		shared.Fatalln(err)
+	}
+
+	if err := SyncConfigDecks(db); err != nil {
+		shared.Fatalln(err)
+	}
+
+	return db
+}
+
+// open opens the SQLite database at dsn, then migrates the schema and the FTS index
+func open(dsn string) (*gorm.DB, error) {
 	db, err := gorm.Open(gormSqlite.Dialector{
 		DriverName: "sqlite3_custom",
-		DSN:        filepath.Join(shared.UserDataDir, shared.Config.DB),
+		DSN:        dsn,
 	}, &gorm.Config{
 		NamingStrategy: schema.NamingStrategy{
 			SingularTable: true,
 		},
 	})
 	if err != nil {
-		shared.Fatalln(err)
+		return nil, err
+	}
+
+	if err := migrateNoteData(db); err != nil {
+		return nil, err
 	}
 
 	if err := db.AutoMigrate(
@@ -61,14 +64,22 @@ func Connect() *gorm.DB {
 		&Template{},
 		&Note{},
 		&NoteAttr{},
+		&NoteRevision{},
 		&Card{},
+		&SavedSearch{},
+		&Media{},
+		&NoteMedia{},
 	); err != nil {
-		shared.Fatalln(err)
+		return nil, err
+	}
+
+	if err := migrateFilename(db); err != nil {
+		return nil, err
 	}
 
 	if err := NoteFTSInit(db); err != nil {
-		shared.Fatalln(err)
+		return nil, err
 	}
 
-	return db
+	return db, nil
 }
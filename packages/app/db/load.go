@@ -1,65 +1,122 @@
 package db
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator"
 	"github.com/google/uuid"
 	"github.com/rep2recall/r2r/browser"
+	"github.com/rep2recall/r2r/segmenter"
 	"github.com/rep2recall/r2r/shared"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
 var validate *validator.Validate
 
+// LoadedModelStruct is a model. Without ID, it is referred to by Name; see ResolveRefs.
+type LoadedModelStruct struct {
+	ID        string                 `validate:"omitempty,uuid" yaml:",omitempty" json:"id,omitempty"`
+	Name      string                 `validate:"required_without=ID" json:"name"`
+	Front     string                 `yaml:",omitempty" json:"front,omitempty"`
+	Back      string                 `yaml:",omitempty" json:"back,omitempty"`
+	Shared    string                 `yaml:",omitempty" json:"shared,omitempty"`
+	Generator map[string]interface{} `validate:"blank-is-string" yaml:",omitempty" json:"generator,omitempty"`
+	Lang      map[string]string      `yaml:",omitempty" json:"lang,omitempty"`
+	Use       []string               `yaml:",omitempty" json:"use,omitempty"` // Names of snippets, prepended to Shared
+}
+
+// LoadedTemplateStruct is a template. Without ID, it is referred to by Name, within its model.
+type LoadedTemplateStruct struct {
+	ID      string `validate:"omitempty,uuid" yaml:",omitempty" json:"id,omitempty"`
+	ModelID string `validate:"omitempty,uuid" yaml:"modelId,omitempty" json:"modelId,omitempty"`
+	Model   string `validate:"required_without=ModelID" yaml:",omitempty" json:"model,omitempty"` // Model name or ID
+	Name    string `validate:"required_without=ID" json:"name"`
+	Front   string `yaml:",omitempty" json:"front,omitempty"`
+	Back    string `yaml:",omitempty" json:"back,omitempty"`
+	Shared  string `yaml:",omitempty" json:"shared,omitempty"`
+	If      string `yaml:",omitempty" json:"if,omitempty"`
+}
+
+// LoadedNoteStruct is a note. Without ID, it is referred to by Key.
 type LoadedNoteStruct struct {
-	Key     string
-	ID      string                 `validate:"required,uuid"`
-	ModelID string                 `validate:"required,uuid" yaml:"modelId"`
-	Data    map[string]interface{} `validate:"required"`
+	Key     string                 `validate:"required_without=ID" json:"key"`
+	ID      string                 `validate:"omitempty,uuid" yaml:",omitempty" json:"id,omitempty"`
+	ModelID string                 `validate:"omitempty,uuid" yaml:"modelId,omitempty" json:"modelId,omitempty"`
+	Model   string                 `validate:"required_without=ModelID" yaml:",omitempty" json:"model,omitempty"` // Model name or ID
+	Data    map[string]interface{} `validate:"required" json:"data"`
+	Tag     []string               `validate:"dive,tag" yaml:",omitempty" json:"tag,omitempty"`
+	Media   map[string]string      `yaml:",omitempty" json:"media,omitempty"` // map[Field]File, relative to this file; see MediaURL
+}
+
+// LoadedCardStruct overrides a card, made from a template and a note
+type LoadedCardStruct struct {
+	ID         string   `validate:"omitempty,uuid" yaml:",omitempty" json:"id,omitempty"`
+	TemplateID string   `validate:"omitempty,uuid" yaml:"templateId,omitempty" json:"templateId,omitempty"`
+	Template   string   `validate:"required_without=TemplateID" yaml:",omitempty" json:"template,omitempty"` // Template name, within the model of the note, or ID
+	NoteID     string   `validate:"omitempty,uuid" yaml:"noteId,omitempty" json:"noteId,omitempty"`
+	Note       string   `validate:"required_without=NoteID" yaml:",omitempty" json:"note,omitempty"` // Note key or ID
+	Tag        []string `validate:"dive,tag" yaml:",omitempty" json:"tag,omitempty"`
+	Front      string   `yaml:",omitempty" json:"front,omitempty"`
+	Back       string   `yaml:",omitempty" json:"back,omitempty"`
+	Shared     string   `yaml:",omitempty" json:"shared,omitempty"`
+}
+
+// LoadedStateStruct is an extension for scheduling state, as exported by Export.
+// Cards are matched by template and note, as card IDs differ between databases.
+type LoadedStateStruct struct {
+	TemplateID  string     `validate:"omitempty,uuid" yaml:"templateId,omitempty" json:"templateId,omitempty"`
+	Template    string     `validate:"required_without=TemplateID" yaml:",omitempty" json:"template,omitempty"`
+	NoteID      string     `validate:"omitempty,uuid" yaml:"noteId,omitempty" json:"noteId,omitempty"`
+	Note        string     `validate:"required_without=NoteID" yaml:",omitempty" json:"note,omitempty"`
+	Tag         []string   `validate:"dive,tag" yaml:",omitempty" json:"tag,omitempty"`
+	Mnemonic    string     `yaml:",omitempty" json:"mnemonic,omitempty"`
+	SRSLevel    int        `yaml:"srsLevel,omitempty" json:"srsLevel,omitempty"`
+	NextReview  *time.Time `yaml:"nextReview,omitempty" json:"nextReview,omitempty"`
+	LastRight   *time.Time `yaml:"lastRight,omitempty" json:"lastRight,omitempty"`
+	LastWrong   *time.Time `yaml:"lastWrong,omitempty" json:"lastWrong,omitempty"`
+	MaxRight    int        `yaml:"maxRight,omitempty" json:"maxRight,omitempty"`
+	MaxWrong    int        `yaml:"maxWrong,omitempty" json:"maxWrong,omitempty"`
+	RightStreak int        `yaml:"rightStreak,omitempty" json:"rightStreak,omitempty"`
+	WrongStreak int        `yaml:"wrongStreak,omitempty" json:"wrongStreak,omitempty"`
 }
 
 type LoadedStruct struct {
-	Model []struct {
-		ID        string `validate:"required,uuid"`
-		Name      string
-		Front     string
-		Back      string
-		Shared    string
-		Generator map[string]interface{} `validate:"blank-is-string"`
-	} `validate:"dive"`
-	Template []struct {
-		ID      string `validate:"required,uuid"`
-		ModelID string `validate:"required,uuid" yaml:"modelId"`
-		Name    string
-		Front   string
-		Back    string
-		Shared  string
-		If      string
-	} `validate:"dive"`
-	Note []LoadedNoteStruct `validate:"dive"`
-	Card []struct {
-		ID         string `validate:"required,uuid"`
-		TemplateID string `validate:"required,uuid" yaml:"templateId"`
-		NoteID     string `validate:"required,uuid" yaml:"noteId"`
-		Tag        []string
-		Front      string
-		Back       string
-		Shared     string
-	} `validate:"dive"`
+	Include  []string               `yaml:",omitempty"` // Other YAML files, relative to this file
+	Snippet  map[string]string      `yaml:",omitempty"` // Named snippets of shared, see LoadedModelStruct.Use
+	Model    []LoadedModelStruct    `validate:"dive" yaml:",omitempty"`
+	Template []LoadedTemplateStruct `validate:"dive" yaml:",omitempty"`
+	Note     []LoadedNoteStruct     `validate:"dive" yaml:",omitempty"`
+	Card     []LoadedCardStruct     `validate:"dive" yaml:",omitempty"`
+	State    []LoadedStateStruct    `validate:"dive" yaml:",omitempty"`
+
+	file    string                // For LoadError
+	node    *yaml.Node            // For line numbers of LoadError, if parsed from YAML
+	origins map[string]loadOrigin // map[Path]loadOrigin of entries, e.g. `note[12]`, from included files and documents
+	cards   map[string]bool       // map[TemplateID/NoteID] of the only cards made for notes in the file, e.g. as in an Anki package; or all, if nil
 }
 
 func ValidateBlankIsString(fl validator.FieldLevel) bool {
+	if fl.Field().IsNil() {
+		return true
+	}
+
 	bl := fl.Field().MapIndex(reflect.ValueOf("_"))
-	if !bl.IsNil() {
+	if bl.IsValid() && !bl.IsNil() {
 		if bl.Elem().Type().String() != "string" {
 			return false
 		}
@@ -68,64 +125,322 @@ func ValidateBlankIsString(fl validator.FieldLevel) bool {
 	return true
 }
 
+func ValidateTagField(fl validator.FieldLevel) bool {
+	return ValidateTag(fl.Field().String()) == nil
+}
+
 type LoadOptions struct {
 	Debug bool
 	Port  int
+	Diff  bool // Record field changes in LoadSummary.Diff, for dry runs; so, media files are not copied
+	Prune bool // Remove notes and cards of the file, which are no longer in it
+	// Replace data fields and tags of notes, and card overrides, rather than only adding; as saved via API
+	Replace bool
+}
+
+// LoadCount counts rows of a table, by what loading did to them
+type LoadCount struct {
+	Created   int
+	Updated   int
+	Unchanged int
+	Deleted   int
+}
+
+func (c *LoadCount) add(isCreated bool, isUpdated bool) {
+	switch {
+	case isCreated:
+		c.Created++
+	case isUpdated:
+		c.Updated++
+	default:
+		c.Unchanged++
+	}
+}
+
+func (c LoadCount) String() string {
+	return fmt.Sprintf("%d created, %d updated, %d unchanged, %d deleted", c.Created, c.Updated, c.Unchanged, c.Deleted)
+}
+
+// LoadSummary is what Load did to a single file
+type LoadSummary struct {
+	File     string
+	Model    LoadCount
+	Template LoadCount
+	Note     LoadCount
+	Card     LoadCount
+	Diff     []LoadChange
+	Changed  []string // IDs of notes updated, e.g. to list their revisions
+}
+
+// LoadChange is a change to a single field, or a deleted row if Key is empty
+type LoadChange struct {
+	Op    string // + for created, ~ for updated, - for deleted
+	Table string
+	ID    string
+	Key   string
+	Old   string
+	New   string
+}
+
+func (c LoadChange) String() string {
+	switch {
+	case c.Key == "":
+		return fmt.Sprintf("%s %s %s", c.Op, c.Table, c.ID)
+	case c.Op == "+":
+		return fmt.Sprintf("%s %s %s %s: %s", c.Op, c.Table, c.ID, c.Key, shorten(c.New))
+	default:
+		return fmt.Sprintf("%s %s %s %s: %s -> %s", c.Op, c.Table, c.ID, c.Key, shorten(c.Old), shorten(c.New))
+	}
+}
+
+// shorten quotes s for a single line, cutting it if too long
+func shorten(s string) string {
+	const maxLength = 60
+
+	r := []rune(s)
+	if len(r) > maxLength {
+		return strconv.Quote(string(r[:maxLength])) + "..."
+	}
+
+	return strconv.Quote(s)
+}
+
+// compare tells whether any of fields have changed from old, recording the changes if diff is set.
+// A nil old means a created row.
+func (s *LoadSummary) compare(diff bool, table string, id string, old map[string]string, fields map[string]string) bool {
+	keys := make([]string, 0)
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	isChanged := false
+	for _, k := range keys {
+		if old == nil {
+			if fields[k] == "" {
+				continue
+			}
+		} else if old[k] == fields[k] {
+			continue
+		}
+
+		isChanged = true
+		if diff {
+			op := "~"
+			if old == nil {
+				op = "+"
+			}
+
+			s.Diff = append(s.Diff, LoadChange{
+				Op:    op,
+				Table: table,
+				ID:    id,
+				Key:   k,
+				Old:   old[k],
+				New:   fields[k],
+			})
+		}
+	}
+
+	return isChanged
 }
 
 func init() {
 	validate = validator.New()
 	validate.RegisterValidation("blank-is-string", ValidateBlankIsString)
+	validate.RegisterValidation("tag", ValidateTagField)
+	validate.RegisterTagNameFunc(yamlTagName)
 }
 
+// LoadStruct reads f, with all its documents and included files; then resolves snippets, and validates
 func LoadStruct(f string) (LoadedStruct, error) {
-	var loadFile LoadedStruct
-
-	b, e := ioutil.ReadFile(filepath.Join(shared.UserDataDir, f))
+	loadFile, e := loadDocuments(f, nil, make(map[string]bool))
 	if e != nil {
 		return loadFile, e
 	}
 
-	if e := yaml.Unmarshal(b, &loadFile); e != nil {
+	loadFile.file = f
+
+	if e := loadFile.resolveSnippets(); e != nil {
 		return loadFile, e
 	}
 
-	if e := validate.Struct(&loadFile); e != nil {
+	if e := loadFile.validate(); e != nil {
 		return loadFile, e
 	}
 
 	return loadFile, nil
 }
 
-func Load(tx *gorm.DB, f string, opts LoadOptions) error {
+// Load loads a YAML file, relative to UserDataDir, and tells what is created, updated or deleted
+func Load(tx *gorm.DB, f string, opts LoadOptions) (LoadSummary, error) {
 	loadFile, e := LoadStruct(f)
 	if e != nil {
-		return e
+		return LoadSummary{File: f}, e
+	}
+
+	return LoadFrom(tx, f, loadFile, opts)
+}
+
+// LoadFrom loads loadFile as if it were read from file f, e.g. for importers
+func LoadFrom(tx *gorm.DB, f string, loadFile LoadedStruct, opts LoadOptions) (LoadSummary, error) {
+	summary := LoadSummary{
+		File: f,
+	}
+
+	if loadFile.file == "" {
+		loadFile.file = f
+	}
+
+	// Card.Filename is LineSeparated
+	if strings.ContainsAny(f, "\r\n") {
+		return summary, fmt.Errorf("file name must not contain line breaks: %q", f)
+	}
+
+	if e := loadFile.validate(); e != nil {
+		return summary, e
+	}
+
+	if e := ResolveRefs(tx, &loadFile); e != nil {
+		return summary, e
+	}
+
+	noteMedia, mediaCopies, e := resolveMedia(tx, f, &loadFile)
+	if e != nil {
+		return summary, e
+	}
+
+	if e := load(tx, f, loadFile, opts, &summary); e != nil {
+		return summary, e
+	}
+
+	// After notes are saved
+	for _, nm := range noteMedia {
+		if r := tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.AssignmentColumns([]string{"hash"}),
+		}).Create(&nm); r.Error != nil {
+			return summary, r.Error
+		}
+	}
+
+	// Fields of media, replaced by other data; but not by the URL of the same media, e.g. as got via API
+	for _, n := range loadFile.Note {
+		for k, v := range n.Data {
+			if _, ok := n.Media[k]; ok {
+				continue
+			}
+
+			rTx := tx.Where("note_id = ? AND key = ?", n.ID, k)
+			if s, ok := v.(string); ok && strings.HasPrefix(s, MediaURL("")) {
+				rTx = rTx.Where("hash <> ?", strings.TrimPrefix(s, MediaURL("")))
+			}
+			if r := rTx.Delete(&NoteMedia{}); r.Error != nil {
+				return summary, r.Error
+			}
+		}
+	}
+
+	// Not on dry runs, as rolled back; nor if loading failed, so that files are not left without Media
+	if !opts.Diff {
+		for _, c := range mediaCopies {
+			if e := copyMedia(c.media, c.path); e != nil {
+				return summary, e
+			}
+		}
 	}
 
+	return summary, nil
+}
+
+// evalJS evaluates generators and template `if` in a browser; replaced in tests, without a browser
+var evalJS = func(scripts []*browser.EvalContext, opts browser.EvalOptions) {
+	browser.Browser{}.Eval(scripts, opts)
+}
+
+func load(tx *gorm.DB, f string, loadFile LoadedStruct, opts LoadOptions, summary *LoadSummary) error {
+	var e error
+
 	modelGenMap := make(map[string]map[string]interface{})
-	noteGenMap := make(map[string]LoadedNoteStruct)
+	modelLangMap := make(map[string]Model)
+	modelIDs := make(map[string]bool)
+	noteLoadMap := make(map[string]LoadedNoteStruct)
 	var toGenerate []*browser.EvalContext
 
+	plugins := []string{}
+
+	e = filepath.Walk(filepath.Join(shared.UserDataDir, "plugins", "js"), func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(path, ".js") {
+			b, e := ioutil.ReadFile(path)
+			if e != nil {
+				return e
+			}
+
+			plugins = append(plugins, string(b))
+		}
+
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+
+	// Generators and template `if` are evaluated again only if their hashes, or of note data, have changed
+	pluginHash := contentHash(plugins...)
+	modelHashMap := make(map[string]string)
+	noteHashMap := make(map[string]string)
+	changedNotes := make(map[string]bool)
+	changedIfs := make(map[string]bool)
+
 	for _, m := range loadFile.Model {
 		if m.Generator != nil {
 			modelGenMap[m.ID] = m.Generator
 		}
 
-		if r := tx.Clauses(clause.OnConflict{
-			UpdateAll: true,
-		}).Create(&Model{
+		var lang MapStringUnknown
+		if m.Lang != nil {
+			lang = MapStringUnknown{}
+			for k, v := range m.Lang {
+				lang[k] = v
+			}
+		}
+
+		model := Model{
 			ID:        m.ID,
 			Name:      m.Name,
 			Front:     m.Front,
 			Back:      m.Back,
 			Shared:    m.Shared,
 			Generator: m.Generator,
-		}); r.Error != nil {
+			Lang:      lang,
+			Hash:      contentHash(pluginHash, jsonString(m.Generator)),
+		}
+		modelLangMap[m.ID] = model
+		modelHashMap[m.ID] = model.Hash
+		modelIDs[m.ID] = true
+
+		var existing Model
+		if r := tx.Where("id = ?", m.ID).Limit(1).Find(&existing); r.Error != nil {
 			return r.Error
 		}
+		var oldFields map[string]string
+		if existing.ID != "" {
+			oldFields = modelFields(existing)
+		}
+		isUpdated := summary.compare(opts.Diff, "model", m.ID, oldFields, modelFields(model))
+		summary.Model.add(existing.ID == "", isUpdated)
 
-		if m.Generator["_"] != nil {
+		if r := tx.Clauses(clause.OnConflict{
+			UpdateAll: true,
+		}).Create(&model); r.Error != nil {
+			return r.Error
+		}
+
+		// Notes not in the file are generated again, only if the generator has changed
+		if m.Generator["_"] != nil && existing.Hash != model.Hash {
 			var notes []Note
 
 			if r := tx.Model(&Note{}).
@@ -136,7 +451,8 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 			}
 
 			for _, n := range notes {
-				noteGenMap[n.ID] = LoadedNoteStruct{
+				changedNotes[n.ID] = true
+				noteLoadMap[n.ID] = LoadedNoteStruct{
 					Key:     n.Key,
 					ID:      n.ID,
 					ModelID: n.ModelID,
@@ -150,16 +466,14 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 						return e
 					}
 
-					noteGenMap[n.ID].Data[key] = v
+					noteLoadMap[n.ID].Data[key] = v
 				}
 			}
 		}
 	}
 
 	for _, t := range loadFile.Template {
-		if r := tx.Clauses(clause.OnConflict{
-			UpdateAll: true,
-		}).Create(&Template{
+		template := Template{
 			ID:      t.ID,
 			ModelID: t.ModelID,
 			Name:    t.Name,
@@ -167,13 +481,36 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 			Back:    t.Back,
 			Shared:  t.Shared,
 			If:      t.If,
-		}); r.Error != nil {
+			Hash:    contentHash(pluginHash, t.If),
+		}
+		modelIDs[t.ModelID] = true
+
+		var existing Template
+		if r := tx.Where("id = ?", t.ID).Limit(1).Find(&existing); r.Error != nil {
+			return r.Error
+		}
+		var oldFields map[string]string
+		if existing.ID != "" {
+			oldFields = templateFields(existing)
+		}
+		isUpdated := summary.compare(opts.Diff, "template", t.ID, oldFields, templateFields(template))
+		summary.Template.add(existing.ID == "", isUpdated)
+
+		if existing.Hash != template.Hash {
+			changedIfs[t.ID] = true
+		}
+
+		if r := tx.Clauses(clause.OnConflict{
+			UpdateAll: true,
+		}).Create(&template); r.Error != nil {
 			return r.Error
 		}
 	}
 
+	fileNoteIDs := make([]string, 0)
+
 	for _, n := range loadFile.Note {
-		if n.ModelID != "" && modelGenMap[n.ModelID] == nil {
+		if _, ok := modelHashMap[n.ModelID]; !ok && n.ModelID != "" {
 			var m Model
 			if r := tx.Where("id = ?", n.ModelID).First(&m); r.Error != nil {
 				return r.Error
@@ -181,15 +518,55 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 			if m.Generator != nil {
 				modelGenMap[n.ModelID] = m.Generator
 			}
+			modelHashMap[n.ModelID] = contentHash(pluginHash, jsonString(m.Generator))
+		}
+
+		datab, e := json.Marshal(n.Data)
+		if e != nil {
+			return e
+		}
+		noteHashMap[n.ID] = contentHash(string(datab))
+		fileNoteIDs = append(fileNoteIDs, n.ID)
+
+		modelIDs[n.ModelID] = true
+		noteLoadMap[n.ID] = n
+	}
+
+	var storedNotes []Note
+	if r := tx.Unscoped().Model(&Note{}).Where("id IN ?", fileNoteIDs).Select("id", "hash", "model_hash").Find(&storedNotes); r.Error != nil {
+		return r.Error
+	}
+	storedNoteMap := make(map[string]Note)
+	for _, n := range storedNotes {
+		storedNoteMap[n.ID] = n
+	}
+	for id, h := range noteHashMap {
+		stored := storedNoteMap[id]
+		if stored.Hash != h || stored.ModelHash != modelHashMap[noteLoadMap[id].ModelID] {
+			changedNotes[id] = true
 		}
+	}
 
-		if modelGenMap[n.ModelID] != nil && modelGenMap[n.ModelID]["_"] != nil {
-			noteGenMap[n.ID] = n
+	// Notes with fields removed are generated again, as generated fields are removed as well
+	if opts.Replace {
+		var storedAttrs []NoteAttr
+		if r := tx.Where("note_id IN ?", fileNoteIDs).Select("note_id", "key").Find(&storedAttrs); r.Error != nil {
+			return r.Error
+		}
+		for _, a := range storedAttrs {
+			if _, ok := noteLoadMap[a.NoteID].Data[a.Key]; !ok {
+				changedNotes[a.NoteID] = true
+			}
 		}
 	}
 
-	for _, n := range noteGenMap {
-		jsb, e := json.Marshal(modelGenMap[n.ModelID]["_"].(string))
+	for _, n := range noteLoadMap {
+		gen, ok := modelGenMap[n.ModelID]["_"].(string)
+		if !ok || !changedNotes[n.ID] {
+			continue
+		}
+
+		jsb, e := json.Marshal(gen)
 		if e != nil {
 			return e
 		}
@@ -220,31 +597,9 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 	}
 
 	noteGenResultMap := make(map[string]map[string]interface{})
-	plugins := []string{}
-
-	e = filepath.Walk(filepath.Join(shared.UserDataDir, "plugins", "js"), func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if strings.HasSuffix(path, ".js") {
-			b, e := ioutil.ReadFile(path)
-			if e != nil {
-				return e
-			}
-
-			plugins = append(plugins, string(b))
-		}
-
-		return nil
-	})
-	if e != nil {
-		return e
-	}
 
 	if len(toGenerate) > 0 {
-		b := browser.Browser{}
-		b.Eval(toGenerate, browser.EvalOptions{
+		evalJS(toGenerate, browser.EvalOptions{
 			Plugins: plugins,
 			Visible: opts.Debug,
 			Port:    opts.Port,
@@ -255,7 +610,10 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 		}
 	}
 
-	for _, n := range noteGenMap {
+	toSegment := make(map[string][]string)
+	noteIDs := make([]string, 0)
+
+	for _, n := range noteLoadMap {
 		if noteGenResultMap[n.ID] != nil {
 			for key, v := range noteGenResultMap[n.ID] {
 				if n.Data[key] == nil {
@@ -264,45 +622,212 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 			}
 		}
 
+		model, ok := modelLangMap[n.ModelID]
+		if !ok {
+			if r := tx.Where("id = ?", n.ModelID).First(&model); r.Error != nil {
+				return r.Error
+			}
+			modelLangMap[n.ModelID] = model
+		}
+
+		noteIDs = append(noteIDs, n.ID)
+		for key, v := range n.Data {
+			value := NoteData{}
+			if err := value.Set(v); err != nil {
+				return err
+			}
+
+			lang := model.FieldLang(key)
+			toSegment[lang] = append(toSegment[lang], value.Raw)
+		}
+	}
+
+	// Segment in batches, both new and old values, before FTS triggers do it one by one
+	var oldAttrs []NoteAttr
+	if r := tx.Where("note_id IN ?", noteIDs).Select("note_id", "key", "value", "lang").Find(&oldAttrs); r.Error != nil {
+		return r.Error
+	}
+	oldAttrMap := make(map[string]map[string]NoteAttr)
+	for _, a := range oldAttrs {
+		toSegment[a.Lang] = append(toSegment[a.Lang], a.Value.Raw)
+
+		if oldAttrMap[a.NoteID] == nil {
+			oldAttrMap[a.NoteID] = make(map[string]NoteAttr)
+		}
+		oldAttrMap[a.NoteID][a.Key] = a
+	}
+	for lang, texts := range toSegment {
+		if e := segmenter.Prefetch(lang, texts); e != nil {
+			return e
+		}
+	}
+
+	for _, n := range noteLoadMap {
 		noteResult := Note{
 			ID:      n.ID,
 			Key:     n.Key,
 			ModelID: n.ModelID,
 		}
 
+		// Restore, if previously pruned; then, cards are evaluated again
+		if r := tx.Unscoped().Model(&Note{}).
+			Where("id = ? AND deleted_at IS NOT NULL", n.ID).
+			Update("deleted_at", nil); r.Error != nil {
+			return r.Error
+		} else if r.RowsAffected > 0 {
+			changedNotes[n.ID] = true
+		}
+
+		var count int64
+		if r := tx.Model(&Note{}).Where("id = ?", n.ID).Count(&count); r.Error != nil {
+			return r.Error
+		}
+
 		if r := tx.FirstOrCreate(&noteResult); r.Error != nil {
 			return r.Error
 		}
 
+		isCreated := count == 0
+		isUpdated := false
+
+		var oldFields map[string]string
+		if !isCreated {
+			oldFields = map[string]string{
+				"key": noteResult.Key,
+				"tag": tagString(noteResult.Tag),
+			}
+			for key, a := range oldAttrMap[n.ID] {
+				oldFields["data."+key] = a.Value.Raw
+			}
+		}
+		fields := make(map[string]string)
+
 		if noteResult.Key != n.Key {
 			noteResult.Key = n.Key
+			isUpdated = true
+		}
+
+		if len(n.Tag) > 0 || opts.Replace {
+			tag, e := noteResult.Tag.Get()
+			if e != nil {
+				return e
+			}
+			if opts.Replace {
+				tag = map[string]bool{}
+			}
+
+			for _, t := range n.Tag {
+				tag[t] = true
+			}
 
-			if r := tx.Updates(&noteResult); r.Error != nil {
+			oldTag := tagString(noteResult.Tag)
+			if e := noteResult.Tag.Set(tag); e != nil {
+				return e
+			}
+			if tagString(noteResult.Tag) != oldTag {
+				isUpdated = true
+			}
+		}
+
+		if isUpdated {
+			if r := tx.Model(&noteResult).Updates(map[string]interface{}{
+				"key": noteResult.Key,
+				"tag": noteResult.Tag,
+			}); r.Error != nil {
 				return r.Error
 			}
 		}
 
+		// Notes not in the file, generated again for the model, keep the hash of their data
+		hashes := map[string]interface{}{}
+		if h, ok := noteHashMap[n.ID]; ok && noteResult.Hash != h {
+			hashes["hash"] = h
+		}
+		if h, ok := modelHashMap[n.ModelID]; ok && noteResult.ModelHash != h {
+			hashes["model_hash"] = h
+		}
+		if len(hashes) > 0 {
+			if r := tx.Model(&Note{}).Where("id = ?", n.ID).Updates(hashes); r.Error != nil {
+				return r.Error
+			}
+		}
+
+		fields["key"] = noteResult.Key
+		fields["tag"] = tagString(noteResult.Tag)
+
+		model := modelLangMap[n.ModelID]
+
+		attrs := make([]NoteAttr, 0)
+		isDataChanged := false
 		for key, v := range n.Data {
 			value := NoteData{}
 			if err := value.Set(v); err != nil {
 				return err
 			}
 
-			if r := tx.Clauses(clause.OnConflict{
-				DoUpdates: clause.AssignmentColumns([]string{"value"}),
-			}).Create(&NoteAttr{
+			fields["data."+key] = value.Raw
+			if a, ok := oldAttrMap[n.ID][key]; ok && a.Lang != model.FieldLang(key) {
+				isUpdated = true
+			}
+			if a, ok := oldAttrMap[n.ID][key]; !ok || a.Value.Raw != value.Raw {
+				isDataChanged = true
+			}
+
+			attrs = append(attrs, NoteAttr{
 				NoteID: noteResult.ID,
 				Key:    key,
 				Value:  value,
-			}); r.Error != nil {
+				Lang:   model.FieldLang(key),
+			})
+		}
+
+		removedKeys := make([]string, 0)
+		if _, ok := noteHashMap[n.ID]; ok && opts.Replace {
+			for key := range oldAttrMap[n.ID] {
+				if _, ok := n.Data[key]; !ok {
+					removedKeys = append(removedKeys, key)
+					isDataChanged = true
+				}
+			}
+		}
+
+		// Previous attrs are kept, before being overwritten
+		if isDataChanged {
+			if e := saveRevision(tx, n.ID, f, oldAttrMap[n.ID]); e != nil {
+				return e
+			}
+		}
+
+		if len(removedKeys) > 0 {
+			if r := tx.Where("note_id = ? AND key IN ?", n.ID, removedKeys).Delete(&NoteAttr{}); r.Error != nil {
+				return r.Error
+			}
+			if r := tx.Where("note_id = ? AND key IN ?", n.ID, removedKeys).Delete(&NoteMedia{}); r.Error != nil {
 				return r.Error
 			}
 		}
+
+		for _, a := range attrs {
+			if r := tx.Clauses(clause.OnConflict{
+				DoUpdates: clause.AssignmentColumns([]string{"value", "lang"}),
+			}).Create(&a); r.Error != nil {
+				return r.Error
+			}
+		}
+
+		if summary.compare(opts.Diff, "note", n.ID, oldFields, fields) {
+			isUpdated = true
+		}
+		summary.Note.add(isCreated, isUpdated)
+		if isUpdated && !isCreated {
+			summary.Changed = append(summary.Changed, n.ID)
+		}
 	}
+	sort.Strings(summary.Changed)
 
 	templateToCreate := make(map[string]Template)
 
-	for mid := range modelGenMap {
+	for mid := range modelIDs {
 		tids := make([]string, 0)
 		for _, t := range loadFile.Template {
 			tids = append(tids, t.ID)
@@ -328,6 +853,41 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 	modelMap := make(map[string]Model)
 	templateMap := make(map[string]Template)
 
+	tids := make([]string, 0)
+	for tid, t := range templateToCreate {
+		tids = append(tids, tid)
+
+		// Templates not in the file, evaluated with other plugins
+		if h := contentHash(pluginHash, t.If); t.Hash != h {
+			changedIfs[tid] = true
+
+			if r := tx.Model(&Template{}).Where("id = ?", tid).Update("hash", h); r.Error != nil {
+				return r.Error
+			}
+		}
+	}
+
+	// Cards of unchanged templates and notes are kept as evaluated before, unless soft-deleted;
+	// and cards deleted by the user are never made again
+	var existingCards []Card
+	if r := tx.Unscoped().Model(&Card{}).
+		Where("template_id IN ?", tids).
+		Select("template_id", "note_id", "deleted_at", "tombstone").
+		Find(&existingCards); r.Error != nil {
+		return r.Error
+	}
+	existingCardMap := make(map[string]bool)
+	tombstoneMap := make(map[string]bool)
+	for _, c := range existingCards {
+		existingCardMap[c.TemplateID+"/"+c.NoteID] = !c.DeletedAt.Valid
+		tombstoneMap[c.TemplateID+"/"+c.NoteID] = c.DeletedAt.Valid && c.Tombstone
+	}
+
+	fileNotes := make(map[string]bool)
+	for _, n := range loadFile.Note {
+		fileNotes[n.ID] = true
+	}
+
 	for tid, t := range templateToCreate {
 		if t.ModelID != "" {
 			var notes []Note
@@ -378,20 +938,35 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 			}
 
 			for nid, n := range noteMap {
-				cardToCompile[uuid.New().String()] = cardPre{
+				ca := cardPre{
 					If:       t.If,
 					NoteID:   nid,
 					Note:     n,
 					Model:    model,
 					Template: template,
 				}
+
+				if tombstoneMap[tid+"/"+nid] {
+					ca.If = "false"
+				} else if loadFile.cards != nil && fileNotes[nid] && !loadFile.cards[tid+"/"+nid] {
+					ca.If = "false"
+				} else if ca.If != "" && !changedIfs[tid] && !changedNotes[nid] {
+					if isLive, ok := existingCardMap[tid+"/"+nid]; !ok {
+						ca.If = "false"
+					} else if isLive {
+						ca.If = "true"
+					}
+				}
+
+				cardToCompile[uuid.New().String()] = ca
 			}
 		}
 	}
 
 	toGenerate = []*browser.EvalContext{}
 	for id, ca := range cardToCompile {
-		if ca.If != "" {
+		// Already evaluated, or kept as before
+		if ca.If != "" && ca.If != "true" && ca.If != "false" {
 			jsb, e := json.Marshal(ca.If)
 			if e != nil {
 				return e
@@ -430,8 +1005,7 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 	}
 
 	if len(toGenerate) > 0 {
-		b := browser.Browser{}
-		b.Eval(toGenerate, browser.EvalOptions{
+		evalJS(toGenerate, browser.EvalOptions{
 			Plugins: plugins,
 			Visible: opts.Debug,
 			Port:    opts.Port,
@@ -455,55 +1029,136 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 		noteMap[n.ID] = true
 	}
 
+	// Cards in the file keep their IDs, when compiled
+	fileCardIDs := make(map[string]string)
+	for _, c := range loadFile.Card {
+		fileCardIDs[c.TemplateID+"/"+c.NoteID] = c.ID
+	}
+	createdCards := make(map[string]bool)
+
 	for id, ca := range cardToCompile {
+		if fid, ok := fileCardIDs[ca.Template.ID+"/"+ca.NoteID]; ok {
+			id = fid
+		}
+
 		if ca.If != "false" {
 			c0 := Card{
 				TemplateID: ca.Template.ID,
 				NoteID:     ca.NoteID,
 			}
 
+			if noteMap[ca.NoteID] {
+				if r := tx.Unscoped().Model(&Card{}).
+					Where("template_id = ? AND note_id = ? AND deleted_at IS NOT NULL", ca.Template.ID, ca.NoteID).
+					Update("deleted_at", nil); r.Error != nil {
+					return r.Error
+				}
+			}
+
 			if r := tx.
 				Where(Card{
 					TemplateID: ca.Template.ID,
 					NoteID:     ca.NoteID,
 				}).
-				Attrs(Card{
-					ID: id,
-				}).
-				FirstOrCreate(&c0); r.Error != nil {
+				Limit(1).
+				Find(&c0); r.Error != nil {
 				return r.Error
 			}
 
+			isCreated := c0.ID == ""
+			if isCreated {
+				c0.ID = id
+				if r := tx.Create(&c0); r.Error != nil {
+					return r.Error
+				}
+				createdCards[id] = true
+			}
+
+			var oldFields map[string]string
+			if !isCreated {
+				oldFields = map[string]string{
+					"templateId": c0.TemplateID,
+					"noteId":     c0.NoteID,
+					"filename":   fileString(c0.Filename),
+				}
+			}
+
 			if noteMap[ca.NoteID] {
 				filename, e := c0.Filename.Get()
 				if e != nil {
 					return e
 				}
 
-				filename[f] = true
+				if !filename[f] {
+					filename[f] = true
 
-				if e := c0.Filename.Set(filename); e != nil {
-					return e
-				}
+					if e := c0.Filename.Set(filename); e != nil {
+						return e
+					}
 
-				if r := tx.
-					Save(&c0); r.Error != nil {
-					return r.Error
+					if r := tx.
+						Save(&c0); r.Error != nil {
+						return r.Error
+					}
 				}
 			}
+
+			isUpdated := summary.compare(opts.Diff, "card", c0.ID, oldFields, map[string]string{
+				"templateId": c0.TemplateID,
+				"noteId":     c0.NoteID,
+				"filename":   fileString(c0.Filename),
+			})
+
+			if isCreated || isUpdated || noteMap[ca.NoteID] {
+				summary.Card.add(isCreated, isUpdated)
+			}
 		} else {
+			var cards []Card
 			if r := tx.
 				Where("template_id = ?", ca.Template.ID).
 				Where("note_id = ?", ca.NoteID).
-				Delete(&Card{}); r.Error != nil {
+				Select("id").
+				Find(&cards); r.Error != nil {
 				return r.Error
 			}
+
+			for _, c := range cards {
+				if r := tx.Delete(&Card{}, "id = ?", c.ID); r.Error != nil {
+					return r.Error
+				}
+
+				summary.Card.Deleted++
+				if opts.Diff {
+					summary.Diff = append(summary.Diff, LoadChange{
+						Op:    "-",
+						Table: "card",
+						ID:    c.ID,
+					})
+				}
+			}
 		}
 	}
 
 	for _, c := range loadFile.Card {
 		c0 := Card{}
 		if c.TemplateID != "" && c.NoteID != "" {
+			// Deleted by the user, see Card.Tombstone
+			var tombstones int64
+			if r := tx.Unscoped().Model(&Card{}).
+				Where("template_id = ? AND note_id = ? AND deleted_at IS NOT NULL AND tombstone", c.TemplateID, c.NoteID).
+				Count(&tombstones); r.Error != nil {
+				return r.Error
+			}
+			if tombstones > 0 {
+				continue
+			}
+
+			if r := tx.Unscoped().Model(&Card{}).
+				Where("template_id = ? AND note_id = ? AND deleted_at IS NOT NULL", c.TemplateID, c.NoteID).
+				Update("deleted_at", nil); r.Error != nil {
+				return r.Error
+			}
+
 			if r := tx.
 				Where("template_id = ?", c.TemplateID).
 				Where("note_id = ?", c.NoteID).
@@ -512,10 +1167,18 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 			}
 		}
 
+		var oldFields map[string]string
+		if c0.ID != "" {
+			oldFields = cardFields(c0)
+		}
+
 		tag, e := c0.Tag.Get()
 		if e != nil {
 			return e
 		}
+		if opts.Replace {
+			tag = map[string]bool{}
+		}
 
 		for _, t := range c.Tag {
 			tag[t] = true
@@ -536,9 +1199,7 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 			return e
 		}
 
-		if r := tx.Clauses(clause.OnConflict{
-			UpdateAll: true,
-		}).Create(&Card{
+		card := Card{
 			ID:         c.ID,
 			TemplateID: c.TemplateID,
 			NoteID:     c.NoteID,
@@ -547,10 +1208,452 @@ func Load(tx *gorm.DB, f string, opts LoadOptions) error {
 			Front:      c.Front,
 			Back:       c.Back,
 			Shared:     c.Shared,
-		}); r.Error != nil {
+		}
+
+		// Only fields of the file, keeping scheduling state and mnemonic; and others as set before, e.g. by the API
+		columns := []string{"updated_at", "template_id", "note_id", "tag", "filename"}
+		if c.Front != "" || opts.Replace {
+			columns = append(columns, "front")
+		} else {
+			card.Front = c0.Front
+		}
+		if c.Back != "" || opts.Replace {
+			columns = append(columns, "back")
+		} else {
+			card.Back = c0.Back
+		}
+		if c.Shared != "" || opts.Replace {
+			columns = append(columns, "shared")
+		} else {
+			card.Shared = c0.Shared
+		}
+
+		isUpdated := summary.compare(opts.Diff, "card", card.ID, oldFields, cardFields(card))
+		if !createdCards[card.ID] {
+			summary.Card.add(c0.ID == "", isUpdated)
+		}
+
+		if r := tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.AssignmentColumns(columns),
+		}).Create(&card); r.Error != nil {
+			return r.Error
+		}
+	}
+
+	for _, st := range loadFile.State {
+		var c0 Card
+		if r := tx.
+			Where("template_id = ?", st.TemplateID).
+			Where("note_id = ?", st.NoteID).
+			Limit(1).
+			Find(&c0); r.Error != nil {
+			return r.Error
+		}
+
+		if c0.ID == "" {
+			shared.Logger.Printf("no card for state of template %s, note %s\n", st.TemplateID, st.NoteID)
+			continue
+		}
+
+		oldFields := stateFields(c0)
+
+		tag := map[string]bool{}
+		for _, t := range st.Tag {
+			tag[t] = true
+		}
+		if e := c0.Tag.Set(tag); e != nil {
+			return e
+		}
+
+		c0.Mnemonic = st.Mnemonic
+		c0.SRSLevel = st.SRSLevel
+		c0.NextReview = st.NextReview
+		c0.LastRight = st.LastRight
+		c0.LastWrong = st.LastWrong
+		c0.MaxRight = st.MaxRight
+		c0.MaxWrong = st.MaxWrong
+		c0.RightStreak = st.RightStreak
+		c0.WrongStreak = st.WrongStreak
+
+		if !summary.compare(opts.Diff, "card", c0.ID, oldFields, stateFields(c0)) {
+			continue
+		}
+
+		// Select all, so that zero values are also saved
+		if r := tx.Model(&Card{}).
+			Where("id = ?", c0.ID).
+			Select("tag", "mnemonic", "srs_level", "next_review", "last_right", "last_wrong", "max_right", "max_wrong", "right_streak", "wrong_streak").
+			Updates(&c0); r.Error != nil {
 			return r.Error
 		}
 	}
 
+	if opts.Prune {
+		return prune(tx, f, loadFile, opts, summary)
+	}
+
 	return nil
 }
+
+// prune removes file f from cards, whose notes or cards are no longer in loadFile;
+// soft-deleting cards with no files left, then notes with no cards left
+func prune(tx *gorm.DB, f string, loadFile LoadedStruct, opts LoadOptions, summary *LoadSummary) error {
+	noteMap := make(map[string]bool)
+	for _, n := range loadFile.Note {
+		noteMap[n.ID] = true
+	}
+
+	cardMap := make(map[string]bool)
+	for _, c := range loadFile.Card {
+		cardMap[c.ID] = true
+	}
+
+	var cards []Card
+	if r := tx.Where(lineLike("filename"), likeEscape(f)).Select("id", "note_id", "filename").Find(&cards); r.Error != nil {
+		return r.Error
+	}
+
+	noteIDs := make([]string, 0)
+
+	for _, c := range cards {
+		if noteMap[c.NoteID] || cardMap[c.ID] {
+			continue
+		}
+
+		filename, e := c.Filename.Get()
+		if e != nil {
+			return e
+		}
+
+		delete(filename, f)
+
+		if len(filename) > 0 {
+			oldFilename := fileString(c.Filename)
+			if e := c.Filename.Set(filename); e != nil {
+				return e
+			}
+
+			if r := tx.Model(&Card{}).Where("id = ?", c.ID).Update("filename", c.Filename); r.Error != nil {
+				return r.Error
+			}
+
+			summary.compare(opts.Diff, "card", c.ID, map[string]string{
+				"filename": oldFilename,
+			}, map[string]string{
+				"filename": fileString(c.Filename),
+			})
+			summary.Card.Updated++
+			continue
+		}
+
+		if r := tx.Model(&Card{}).Where("id = ?", c.ID).Update("filename", ""); r.Error != nil {
+			return r.Error
+		}
+		if r := tx.Delete(&Card{}, "id = ?", c.ID); r.Error != nil {
+			return r.Error
+		}
+
+		summary.Card.Deleted++
+		if opts.Diff {
+			summary.Diff = append(summary.Diff, LoadChange{
+				Op:    "-",
+				Table: "card",
+				ID:    c.ID,
+			})
+		}
+
+		noteIDs = append(noteIDs, c.NoteID)
+	}
+
+	var notes []Note
+	if r := tx.
+		Where("id IN ?", noteIDs).
+		Where("id NOT IN (?)", tx.Model(&Card{}).Select("note_id")).
+		Select("id").
+		Find(&notes); r.Error != nil {
+		return r.Error
+	}
+
+	for _, n := range notes {
+		if r := tx.Delete(&Note{}, "id = ?", n.ID); r.Error != nil {
+			return r.Error
+		}
+
+		summary.Note.Deleted++
+		if opts.Diff {
+			summary.Diff = append(summary.Diff, LoadChange{
+				Op:    "-",
+				Table: "note",
+				ID:    n.ID,
+			})
+		}
+	}
+
+	return nil
+}
+
+// loadFileName is path, relative to the current directory, as file names of cards, see Card.Filename;
+// i.e. relative to the user data directory if inside it, otherwise absolute; as in ResolveFiles
+func loadFileName(path string) string {
+	abs, e := filepath.Abs(path)
+	if e != nil {
+		return path
+	}
+
+	if rel, e := filepath.Rel(shared.UserDataDir, abs); e == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return abs
+}
+
+// ResolveFiles expands files, directories and glob patterns, relative to UserDataDir,
+// into YAML files. Directories are walked recursively, skipping hidden ones.
+func ResolveFiles(patterns []string) ([]string, error) {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+
+	add := func(path string) {
+		path = loadFileName(path)
+
+		if !seen[path] {
+			seen[path] = true
+			out = append(out, path)
+		}
+	}
+
+	for _, p := range patterns {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(shared.UserDataDir, p)
+		}
+
+		matches, e := filepath.Glob(p)
+		if e != nil {
+			return nil, e
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", p)
+		}
+
+		for _, m := range matches {
+			info, e := os.Stat(m)
+			if e != nil {
+				return nil, e
+			}
+
+			if !info.IsDir() {
+				add(m)
+				continue
+			}
+
+			files := make([]string, 0)
+			if e := filepath.Walk(m, func(path string, info fs.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+
+				if info.IsDir() {
+					if path != m && strings.HasPrefix(info.Name(), ".") {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				if isLoadFile(path) {
+					files = append(files, path)
+				}
+
+				return nil
+			}); e != nil {
+				return nil, e
+			}
+
+			sort.Strings(files)
+			for _, f := range files {
+				add(f)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// LoadFiles loads all files matched by patterns, in order. See ResolveFiles.
+func LoadFiles(tx *gorm.DB, patterns []string, opts LoadOptions) ([]LoadSummary, error) {
+	files, e := ResolveFiles(patterns)
+	if e != nil {
+		return nil, e
+	}
+
+	// Reports problems of all files, before loading any
+	loaded := make([]LoadedStruct, 0)
+	var errs LoadErrors
+
+	// References are resolved across files, of valid ones, as entries of earlier files are loaded before later ones
+	all := LoadedStruct{
+		origins: make(map[string]loadOrigin),
+	}
+
+	for _, f := range files {
+		loadFile, e := LoadStruct(f)
+		if e != nil {
+			var lerrs LoadErrors
+			if errors.As(e, &lerrs) {
+				errs = append(errs, lerrs...)
+			} else {
+				errs = append(errs, LoadError{File: f, Message: e.Error()})
+			}
+		} else {
+			all.merge(loadFile)
+		}
+		loaded = append(loaded, loadFile)
+	}
+
+	if e := ResolveRefs(tx, &all); e != nil {
+		var lerrs LoadErrors
+		if !errors.As(e, &lerrs) {
+			return nil, e
+		}
+		errs = append(errs, lerrs...)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	out := make([]LoadSummary, 0)
+	for i, f := range files {
+		summary, e := LoadFrom(tx, f, loaded[i], opts)
+		if e != nil {
+			var lerrs LoadErrors
+			if errors.As(e, &lerrs) {
+				return out, e
+			}
+			return out, fmt.Errorf("%s: %w", f, e)
+		}
+		out = append(out, summary)
+	}
+
+	return out, nil
+}
+
+// modelFields are fields of Model compared by Load
+func modelFields(m Model) map[string]string {
+	return map[string]string{
+		"name":      m.Name,
+		"front":     m.Front,
+		"back":      m.Back,
+		"shared":    m.Shared,
+		"generator": jsonString(m.Generator),
+		"lang":      jsonString(m.Lang),
+	}
+}
+
+// templateFields are fields of Template compared by Load
+func templateFields(t Template) map[string]string {
+	return map[string]string{
+		"modelId": t.ModelID,
+		"name":    t.Name,
+		"front":   t.Front,
+		"back":    t.Back,
+		"shared":  t.Shared,
+		"if":      t.If,
+	}
+}
+
+// cardFields are fields of Card, loaded from YAML, compared by Load
+func cardFields(c Card) map[string]string {
+	return map[string]string{
+		"id":         c.ID,
+		"templateId": c.TemplateID,
+		"noteId":     c.NoteID,
+		"front":      c.Front,
+		"back":       c.Back,
+		"shared":     c.Shared,
+		"tag":        tagString(c.Tag),
+		"filename":   fileString(c.Filename),
+	}
+}
+
+// stateFields are scheduling fields of Card, loaded from the state section
+func stateFields(c Card) map[string]string {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	return map[string]string{
+		"tag":         tagString(c.Tag),
+		"mnemonic":    c.Mnemonic,
+		"srsLevel":    strconv.Itoa(c.SRSLevel),
+		"nextReview":  formatTime(c.NextReview),
+		"lastRight":   formatTime(c.LastRight),
+		"lastWrong":   formatTime(c.LastWrong),
+		"maxRight":    strconv.Itoa(c.MaxRight),
+		"maxWrong":    strconv.Itoa(c.MaxWrong),
+		"rightStreak": strconv.Itoa(c.RightStreak),
+		"wrongStreak": strconv.Itoa(c.WrongStreak),
+	}
+}
+
+// contentHash is the hash of parts, e.g. to tell whether generators have to be evaluated again
+func contentHash(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func jsonString(m MapStringUnknown) string {
+	if m == nil {
+		return ""
+	}
+
+	b, e := json.Marshal(m)
+	if e != nil {
+		return fmt.Sprint(map[string]interface{}(m))
+	}
+
+	return string(b)
+}
+
+// tagString formats SpaceSeparated as sorted, so that it can be compared
+func tagString(s SpaceSeparated) string {
+	set, e := s.Get()
+	if e != nil {
+		return s.Raw
+	}
+
+	out := make([]string, 0)
+	for k, v := range set {
+		if v {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+
+	return strings.Join(out, " ")
+}
+
+// fileString is LineSeparated, sorted for comparison
+func fileString(s LineSeparated) string {
+	set, e := s.Get()
+	if e != nil {
+		return s.Raw
+	}
+
+	out := make([]string, 0)
+	for k, v := range set {
+		if v {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+
+	return strings.Join(out, ", ")
+}
@@ -0,0 +1,675 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rep2recall/r2r/browser"
+	"github.com/rep2recall/r2r/shared"
+)
+
+const loadFixture = `
+model:
+  - id: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01
+    name: vocab
+    front: "{{ it.word }}"
+template:
+  - id: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d02
+    modelId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01
+    name: forward
+note:
+  - id: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03
+    key: vocab-1
+    modelId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01
+    data:
+      word: %s
+`
+
+// withUserDataDir points UserDataDir to a temporary directory, with files relative to it
+func withUserDataDir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+
+	userDataDir := shared.UserDataDir
+	t.Cleanup(func() {
+		shared.UserDataDir = userDataDir
+	})
+	shared.UserDataDir = dir
+
+	if e := os.MkdirAll(filepath.Join(dir, "plugins", "js"), 0755); e != nil {
+		t.Fatal(e)
+	}
+
+	for f, content := range files {
+		p := filepath.Join(dir, f)
+		if e := os.MkdirAll(filepath.Dir(p), 0755); e != nil {
+			t.Fatal(e)
+		}
+		if e := os.WriteFile(p, []byte(content), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	return dir
+}
+
+func TestResolveFiles(t *testing.T) {
+	withUserDataDir(t, map[string]string{
+		"a.yaml":              "",
+		"deck/b.yml":          "",
+		"deck/sub/c.yaml":     "",
+		"deck/notes.txt":      "",
+		"deck/.hidden/d.yaml": "",
+	})
+
+	files, e := ResolveFiles([]string{"deck", "*.yaml", "deck/sub/c.yaml"})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	expected := strings.Join([]string{
+		"deck/b.yml",
+		filepath.Join("deck", "sub", "c.yaml"),
+		"a.yaml",
+	}, ",")
+	if r := strings.Join(files, ","); r != expected {
+		t.Errorf("expected [%s], got [%s]", expected, r)
+	}
+
+	if _, e := ResolveFiles([]string{"missing.yaml"}); e == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestLoadSummary(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1),
+	})
+
+	summaries, e := LoadFiles(tx, []string{"."}, LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(summaries))
+	}
+
+	s := summaries[0]
+	for name, c := range map[string]LoadCount{
+		"model":    s.Model,
+		"template": s.Template,
+		"note":     s.Note,
+		"card":     s.Card,
+	} {
+		if c != (LoadCount{Created: 1}) {
+			t.Errorf("first load: expected 1 %s created, got %s", name, c)
+		}
+	}
+
+	if e := os.WriteFile(filepath.Join(dir, "vocab.yaml"), []byte(strings.Replace(loadFixture, "%s", "two", 1)), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	s, e = Load(tx, "vocab.yaml", LoadOptions{Diff: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	diff := make([]string, 0)
+	for _, c := range s.Diff {
+		diff = append(diff, c.String())
+	}
+	expected := `~ note 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03 data.word: "one" -> "two"`
+	if r := strings.Join(diff, "\n"); r != expected {
+		t.Errorf("expected diff [%s], got [%s]", expected, r)
+	}
+
+	if s.Model != (LoadCount{Unchanged: 1}) {
+		t.Errorf("second load: expected model unchanged, got %s", s.Model)
+	}
+	if s.Note != (LoadCount{Updated: 1}) {
+		t.Errorf("second load: expected note updated, got %s", s.Note)
+	}
+	if s.Card != (LoadCount{Unchanged: 1}) {
+		t.Errorf("second load: expected card unchanged, got %s", s.Card)
+	}
+}
+
+func TestLoadNumericString(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, nil)
+
+	for _, word := range []string{"007", "1.50", "1e3"} {
+		if e := os.WriteFile(filepath.Join(dir, "vocab.yaml"), []byte(fmt.Sprintf(loadFixture, `"`+word+`"`)), 0644); e != nil {
+			t.Fatal(e)
+		}
+
+		if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+			t.Fatal(e)
+		}
+
+		note, e := GetNote(tx, "7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03")
+		if e != nil {
+			t.Fatal(e)
+		}
+		if note.Data["word"] != word {
+			t.Errorf("expected [%s], got [%v]", word, note.Data["word"])
+		}
+
+		if out := searchIDs(t, tx, `word=`+word); out == "" {
+			t.Errorf("expected exact match of %s", word)
+		}
+	}
+}
+
+func TestLoadPrune(t *testing.T) {
+	tx := testDB(t)
+	second := `
+  - id: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d04
+    key: vocab-3
+    modelId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01
+    data:
+      word: three
+`
+	dir := withUserDataDir(t, map[string]string{
+		"my deck, v2.yaml": strings.Replace(loadFixture, "%s", "one", 1) + second,
+	})
+
+	if _, e := Load(tx, "my deck, v2.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	if e := os.WriteFile(filepath.Join(dir, "my deck, v2.yaml"), []byte(strings.Replace(loadFixture, "%s", "one", 1)), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	s, e := Load(tx, "my deck, v2.yaml", LoadOptions{Prune: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if s.Note.Deleted != 1 || s.Card.Deleted != 1 {
+		t.Errorf("expected 1 note and 1 card deleted, got note [%s], card [%s]", s.Note, s.Card)
+	}
+
+	var count int64
+	if r := tx.Model(&Card{}).Count(&count); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 card left, got %d", count)
+	}
+
+	var card Card
+	if r := tx.First(&card); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if filename, _ := card.Filename.Get(); !filename["my deck, v2.yaml"] || len(filename) != 1 {
+		t.Errorf("expected filename with spaces kept, got %q", card.Filename.Raw)
+	}
+
+	// Loading again restores
+	if e := os.WriteFile(filepath.Join(dir, "my deck, v2.yaml"), []byte(strings.Replace(loadFixture, "%s", "one", 1)+second), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	if _, e := Load(tx, "my deck, v2.yaml", LoadOptions{Prune: true}); e != nil {
+		t.Fatal(e)
+	}
+
+	if r := tx.Model(&Card{}).Count(&count); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 cards after restoring, got %d", count)
+	}
+}
+
+func TestMigrateFilename(t *testing.T) {
+	tx := searchFixture(t)
+
+	if r := tx.Exec(`UPDATE card SET filename = ' a.yaml deck/b.yaml ' WHERE id = 'c1'`); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if e := migrateFilename(tx); e != nil {
+		t.Fatal(e)
+	}
+
+	var card Card
+	if r := tx.Where("id = ?", "c1").First(&card); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if out := fileString(card.Filename); out != "a.yaml, deck/b.yaml" {
+		t.Errorf("expected [a.yaml, deck/b.yaml], got [%s]", out)
+	}
+}
+
+func TestLoadKeyRefs(t *testing.T) {
+	tx := testDB(t)
+	withUserDataDir(t, map[string]string{
+		"vocab.yaml": `
+model:
+  - name: vocab
+template:
+  - model: vocab
+    name: forward
+    front: "{{ it.word }}"
+note:
+  - key: vocab-1
+    model: vocab
+    data:
+      word: one
+card:
+  - note: vocab-1
+    template: forward
+    front: custom
+`,
+	})
+
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	var c Card
+	if r := tx.Preload("Note").Preload("Template").First(&c); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	if c.Note.ID != keyID("note", "vocab-1") || c.Template.ModelID != keyID("model", "vocab") || c.Front != "custom" {
+		t.Errorf("expected IDs derived from keys, got %+v", c)
+	}
+
+	s, e := Load(tx, "vocab.yaml", LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for name, c := range map[string]LoadCount{
+		"model":    s.Model,
+		"template": s.Template,
+		"note":     s.Note,
+		"card":     s.Card,
+	} {
+		if c.Created > 0 || c.Updated > 0 {
+			t.Errorf("second load: expected %s unchanged, got %s", name, c)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tx := testDB(t)
+	withUserDataDir(t, map[string]string{
+		"vocab.yaml": `
+model:
+  - name: vocab
+template:
+  - modelId: not-a-uuid
+    name: forward
+note:
+  - key: vocab-1
+    model: nonexistent
+  - key: vocab-2
+    model: vocab
+card:
+  - note: vocab-3
+    template: forward
+`,
+	})
+
+	_, e := LoadFiles(tx, []string{"vocab.yaml"}, LoadOptions{})
+
+	var errs LoadErrors
+	if !errors.As(e, &errs) {
+		t.Fatalf("expected LoadErrors, got %v", e)
+	}
+	fields := make([]string, 0)
+	for _, le := range errs {
+		fields = append(fields, fmt.Sprintf("%d:%s", le.Line, le.Field))
+	}
+	if strings.Join(fields, ",") != "5:template[0].modelId,8:note[0].data,10:note[1].data" {
+		t.Fatalf("expected all invalid fields, got %v", e)
+	}
+
+	// Referential checks, after fields are valid
+	if e := os.WriteFile(filepath.Join(shared.UserDataDir, "vocab.yaml"), []byte(`
+model:
+  - name: vocab
+template:
+  - model: vocab
+    name: forward
+note:
+  - key: vocab-1
+    model: nonexistent
+    data: {}
+  - key: vocab-2
+    model: vocab
+    data: {}
+card:
+  - note: vocab-3
+    template: forward
+`), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	_, e = LoadFiles(tx, []string{"vocab.yaml"}, LoadOptions{})
+	if !errors.As(e, &errs) {
+		t.Fatalf("expected LoadErrors, got %v", e)
+	}
+
+	expected := []string{
+		"vocab.yaml:9:12: note[0].model: no such model: nonexistent",
+		"vocab.yaml:15:11: card[0].note: no such note: vocab-3",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), e)
+	}
+	for i, s := range expected {
+		if errs[i].Error() != s {
+			t.Errorf("expected [%s], got [%s]", s, errs[i].Error())
+		}
+	}
+
+	var count int64
+	tx.Model(&Model{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected nothing loaded, got %d models", count)
+	}
+
+	// Of all files at once, with references to earlier files
+	if e := os.WriteFile(filepath.Join(shared.UserDataDir, "a.yaml"), []byte(`
+model:
+  - name: vocab
+note:
+  - key: vocab-1
+    model: nonexistent
+    data: {}
+`), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(filepath.Join(shared.UserDataDir, "b.yaml"), []byte(`
+note:
+  - key: vocab-2
+    model: vocab
+    data: {}
+card:
+  - note: vocab-3
+    template: forward
+`), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	_, e = LoadFiles(tx, []string{"a.yaml", "b.yaml"}, LoadOptions{})
+	expected = []string{
+		"a.yaml:6:12: note[0].model: no such model: nonexistent",
+		"b.yaml:7:11: card[0].note: no such note: vocab-3",
+	}
+	if !errors.As(e, &errs) || len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), e)
+	}
+	for i, s := range expected {
+		if errs[i].Error() != s {
+			t.Errorf("expected [%s], got [%s]", s, errs[i].Error())
+		}
+	}
+
+	tx.Model(&Model{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected nothing loaded, got %d models", count)
+	}
+}
+
+// withEval replaces evaluating in a browser, with generators doing nothing and template `if` true; returning the count of scripts
+func withEval(t *testing.T) *int {
+	count := 0
+	idRegex := regexp.MustCompile(`id: ("[^"]*")`)
+
+	eval := evalJS
+	t.Cleanup(func() {
+		evalJS = eval
+	})
+	evalJS = func(scripts []*browser.EvalContext, opts browser.EvalOptions) {
+		for _, s := range scripts {
+			count++
+
+			var id string
+			if e := json.Unmarshal([]byte(idRegex.FindStringSubmatch(s.JS)[1]), &id); e != nil {
+				t.Fatal(e)
+			}
+
+			if strings.Contains(s.JS, "rendered:") {
+				s.Output = map[string]interface{}{"id": id, "rendered": true}
+			} else {
+				s.Output = map[string]interface{}{"id": id, "data": map[string]interface{}{}}
+			}
+		}
+	}
+
+	return &count
+}
+
+func TestLoadIncremental(t *testing.T) {
+	tx := testDB(t)
+	modelFile := `
+model:
+  - id: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01
+    name: vocab
+    generator:
+      _: "<%% it.%s = 1 %%>"
+template:
+  - id: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d04
+    modelId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01
+    name: conditional
+    if: "<%%= it.word === 'one' %%>"
+`
+	withUserDataDir(t, map[string]string{
+		"model.yaml": fmt.Sprintf(modelFile, "a"),
+		"vocab.yaml": `
+note:
+  - id: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03
+    key: vocab-1
+    modelId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01
+    data:
+      word: one
+`,
+	})
+	evaluated := withEval(t)
+
+	load := func(f string, expected int) LoadSummary {
+		t.Helper()
+
+		*evaluated = 0
+		s, e := Load(tx, f, LoadOptions{})
+		if e != nil {
+			t.Fatal(e)
+		}
+		if *evaluated != expected {
+			t.Errorf("%s: expected %d evaluated, got %d", f, expected, *evaluated)
+		}
+
+		return s
+	}
+
+	load("model.yaml", 0)
+	// The generator, and the template `if`
+	load("vocab.yaml", 2)
+
+	s := load("vocab.yaml", 0)
+	if s.Note != (LoadCount{Unchanged: 1}) {
+		t.Errorf("expected note unchanged, got %s", s.Note)
+	}
+
+	var count int64
+	tx.Model(&Card{}).Where("template_id = ?", "7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d04").Count(&count)
+	if count != 1 {
+		t.Errorf("expected the conditional card kept, got %d", count)
+	}
+
+	// Notes of the model, not in the file, are generated again; but not again on loading their file
+	if e := os.WriteFile(filepath.Join(shared.UserDataDir, "model.yaml"), []byte(fmt.Sprintf(modelFile, "b")), 0644); e != nil {
+		t.Fatal(e)
+	}
+	load("model.yaml", 2)
+	load("vocab.yaml", 0)
+	load("model.yaml", 0)
+}
+
+func TestLoadInclude(t *testing.T) {
+	tx := testDB(t)
+	withUserDataDir(t, map[string]string{
+		"common/speak.yaml": `
+snippet:
+  speak: <script>window.speak = () => {}</script>
+`,
+		"deck/vocab.yaml": `
+include:
+  - ../common/speak.yaml
+model:
+  - name: vocab
+    use: [speak]
+    shared: <style></style>
+template:
+  - model: vocab
+    name: forward
+    front: "{{ it.word }}"
+---
+note:
+  - key: vocab-1
+    model: vocab
+    data:
+      word: one
+`,
+		"cycle/a.yaml": `
+include: [b.yaml]
+`,
+		"cycle/b.yaml": `
+include: [a.yaml]
+`,
+		"missing.yaml": `
+include: [common/other.yaml]
+`,
+		"common/other.yaml": `
+model:
+  - name: other
+    use: [nonexistent]
+`,
+	})
+
+	s, e := Load(tx, "deck/vocab.yaml", LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.Model.Created != 1 || s.Note.Created != 1 || s.Card.Created != 1 {
+		t.Errorf("expected entries of all documents, got model [%s], note [%s], card [%s]", s.Model, s.Note, s.Card)
+	}
+
+	var m Model
+	if r := tx.First(&m); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	expected := "<script>window.speak = () => {}</script>\n<style></style>"
+	if m.Shared != expected {
+		t.Errorf("expected shared [%s], got [%s]", expected, m.Shared)
+	}
+
+	// Files of only snippets, e.g. in a directory to load, load nothing
+	if _, e := Load(tx, "common/speak.yaml", LoadOptions{}); e != nil {
+		t.Error(e)
+	}
+
+	_, e = LoadStruct("cycle/a.yaml")
+	var errs LoadErrors
+	if !errors.As(e, &errs) {
+		t.Fatalf("expected LoadErrors, got %v", e)
+	}
+	expectedErr := "cycle/b.yaml:2:11: include[0]: include cycle: cycle/a.yaml -> cycle/b.yaml -> cycle/a.yaml"
+	if len(errs) != 1 || errs[0].Error() != expectedErr {
+		t.Errorf("expected [%s], got [%v]", expectedErr, e)
+	}
+
+	// Positioned in the included file
+	_, e = LoadStruct("missing.yaml")
+	expectedErr = "common/other.yaml:4:11: model[0].use[0]: no such snippet: nonexistent"
+	if e == nil || e.Error() != expectedErr {
+		t.Errorf("expected [%s], got [%v]", expectedErr, e)
+	}
+}
+
+func TestLoadKeepsProgress(t *testing.T) {
+	tx := testDB(t)
+	override := `
+card:
+  - templateId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d02
+    noteId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03
+    front: %s
+    tag: [verb]
+`
+	dir := withUserDataDir(t, map[string]string{
+		"vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1) + strings.Replace(override, "%s", "custom", 1),
+	})
+
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	nextReview := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	if r := tx.Model(&Card{}).Where("TRUE").Updates(map[string]interface{}{
+		"srs_level":    3,
+		"next_review":  nextReview,
+		"mnemonic":     "won",
+		"right_streak": 2,
+	}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	for _, front := range []string{"custom", "changed"} {
+		if e := os.WriteFile(filepath.Join(dir, "vocab.yaml"), []byte(strings.Replace(loadFixture, "%s", "one", 1)+strings.Replace(override, "%s", front, 1)), 0644); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+			t.Fatal(e)
+		}
+
+		var c Card
+		if r := tx.First(&c); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+
+		if c.Front != front || tagString(c.Tag) != "verb" {
+			t.Errorf("expected front %s, got %+v", front, c)
+		}
+		if c.SRSLevel != 3 || c.NextReview == nil || !c.NextReview.Equal(nextReview) || c.Mnemonic != "won" || c.RightStreak != 2 {
+			t.Errorf("expected progress kept, got %+v", c)
+		}
+	}
+
+	// Without front, the front set before is kept, e.g. by PUT /api/card
+	if r := tx.Model(&Card{}).Where("TRUE").Update("front", "from api"); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if e := os.WriteFile(filepath.Join(dir, "vocab.yaml"), []byte(strings.Replace(loadFixture, "%s", "one", 1)+`
+card:
+  - templateId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d02
+    noteId: 7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03
+    tag: [noun]
+`), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	var c Card
+	if r := tx.First(&c); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if c.Front != "from api" || tagString(c.Tag) != "noun verb" {
+		t.Errorf("expected front kept and tag added, got %+v", c)
+	}
+}
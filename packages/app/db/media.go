@@ -0,0 +1,245 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/rep2recall/r2r/shared"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Media is a file in the media directory, named by the hash of its content
+type Media struct {
+	Hash      string `gorm:"primarykey;not null"`
+	CreatedAt time.Time
+	Name      string // Original file name, e.g. for export
+	Type      string // MIME type
+	Size      int64
+}
+
+// NoteMedia attaches media to notes, as data fields of MediaURL
+type NoteMedia struct {
+	NoteID string `gorm:"primarykey;not null"`
+	Note   Note   `gorm:"constraint:OnDelete:CASCADE"`
+	Key    string `gorm:"primarykey;not null"`
+	Hash   string `gorm:"index"`
+}
+
+var mediaHashRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// IsMediaHash tells whether s can be a hash of Media, e.g. to be safe as a file name
+func IsMediaHash(s string) bool {
+	return mediaHashRegex.MatchString(s)
+}
+
+// MediaURL is where the Media is served, to be used in templates
+func MediaURL(hash string) string {
+	return "/media/" + hash
+}
+
+// MediaPath is the path of the Media file, in the media directory
+func MediaPath(hash string) string {
+	return filepath.Join(shared.UserDataDir, "media", hash)
+}
+
+// createMedia hashes file at path, and saves it as Media; the file is copied later, by copyMedia
+func createMedia(tx *gorm.DB, path string) (Media, error) {
+	m := Media{
+		Name: filepath.Base(path),
+	}
+
+	f, e := os.Open(path)
+	if e != nil {
+		return m, e
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, e := io.Copy(h, f)
+	if e != nil {
+		return m, e
+	}
+	m.Hash = hex.EncodeToString(h.Sum(nil))
+	m.Size = size
+
+	m.Type = mime.TypeByExtension(filepath.Ext(path))
+	if m.Type == "" {
+		head := make([]byte, 512)
+		n, _ := f.ReadAt(head, 0)
+		m.Type = http.DetectContentType(head[:n])
+	}
+
+	if r := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&m); r.Error != nil {
+		return m, r.Error
+	}
+
+	return m, nil
+}
+
+// copyMedia copies file at path into the media directory, as m, unless it is already there
+func copyMedia(m Media, path string) error {
+	if _, e := os.Stat(MediaPath(m.Hash)); os.IsNotExist(e) {
+		return copyFile(path, MediaPath(m.Hash))
+	}
+
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	if e := os.MkdirAll(filepath.Dir(dst), 0755); e != nil {
+		return e
+	}
+
+	in, e := os.Open(src)
+	if e != nil {
+		return e
+	}
+	defer in.Close()
+
+	out, e := os.Create(dst)
+	if e != nil {
+		return e
+	}
+
+	if _, e := io.Copy(out, in); e != nil {
+		out.Close()
+		return e
+	}
+
+	return out.Close()
+}
+
+// mediaCopy is a file to be copied into the media directory, after loading, by copyMedia
+type mediaCopy struct {
+	media Media
+	path  string
+}
+
+// resolveMedia creates media of notes, relative to file f, setting their data fields to MediaURL.
+// Missing files are reported as LoadErrors; and attachments are returned, to be saved after notes,
+// with files to be copied, only if loaded.
+func resolveMedia(tx *gorm.DB, f string, loadFile *LoadedStruct) ([]NoteMedia, []mediaCopy, error) {
+	out := make([]NoteMedia, 0)
+	copies := make([]mediaCopy, 0)
+	var errs LoadErrors
+
+	for i, n := range loadFile.Note {
+		keys := make([]string, 0)
+		for k := range n.Media {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := n.Media[k]
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(filepath.Dir(userDataPath(f)), p)
+			}
+
+			m, e := createMedia(tx, p)
+			if e != nil {
+				if os.IsNotExist(e) {
+					errs = append(errs, loadFile.errorAt(fmt.Sprintf("note[%d].media.%s", i, k), "no such file: "+n.Media[k]))
+					continue
+				}
+				return nil, nil, e
+			}
+			copies = append(copies, mediaCopy{
+				media: m,
+				path:  p,
+			})
+
+			n.Data[k] = MediaURL(m.Hash)
+			out = append(out, NoteMedia{
+				NoteID: n.ID,
+				Key:    k,
+				Hash:   m.Hash,
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	return out, copies, nil
+}
+
+// UnusedMedia are media not attached to any note, including soft-deleted notes
+func UnusedMedia(tx *gorm.DB) ([]Media, error) {
+	var out []Media
+	if r := tx.
+		Where("hash NOT IN (SELECT hash FROM note_media)").
+		Order("created_at").
+		Find(&out); r.Error != nil {
+		return nil, r.Error
+	}
+
+	return out, nil
+}
+
+// DeleteMedia deletes media, with their files
+func DeleteMedia(tx *gorm.DB, media []Media) error {
+	for _, m := range media {
+		if r := tx.Delete(&Media{}, "hash = ?", m.Hash); r.Error != nil {
+			return r.Error
+		}
+
+		if e := os.Remove(MediaPath(m.Hash)); e != nil && !os.IsNotExist(e) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// exportMedia copies media of note into opts.MediaDir, replacing their data fields by paths relative to the exported file.
+// names are taken names of media, map[Name]Hash, so that different files of the same name are kept.
+func exportMedia(tx *gorm.DB, note *LoadedNoteStruct, opts ExportOptions, names map[string]string) error {
+	var attached []NoteMedia
+	if r := tx.Where("note_id = ?", note.ID).Order("key").Find(&attached); r.Error != nil {
+		return r.Error
+	}
+
+	for _, a := range attached {
+		var m Media
+		if r := tx.Where("hash = ?", a.Hash).First(&m); r.Error != nil {
+			return r.Error
+		}
+
+		name := m.Name
+		if h, ok := names[name]; ok && h != m.Hash {
+			name = m.Hash[:8] + "-" + name
+		}
+
+		p := filepath.Join(opts.MediaDir, name)
+		if _, ok := names[name]; !ok {
+			dst := p
+			if !filepath.IsAbs(dst) {
+				dst = filepath.Join(opts.Dir, dst)
+			}
+			if e := copyFile(MediaPath(m.Hash), dst); e != nil {
+				return e
+			}
+		}
+		names[name] = m.Hash
+
+		if note.Media == nil {
+			note.Media = make(map[string]string)
+		}
+		note.Media[a.Key] = filepath.ToSlash(p)
+		delete(note.Data, a.Key)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mediaFixture = `
+model:
+  - name: vocab
+template:
+  - model: vocab
+    name: forward
+    front: <audio src="<%= it.audio %>"></audio>
+note:
+  - key: vocab-1
+    model: vocab
+    data:
+      word: one
+    media:
+      audio: %s
+`
+
+func TestLoadMedia(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"deck/vocab.yaml":    strings.Replace(mediaFixture, "%s", "audio/one.mp3", 1),
+		"deck/audio/one.mp3": "one",
+		"deck/missing.yaml":  strings.Replace(mediaFixture, "%s", "audio/two.mp3", 1),
+	})
+
+	if _, e := Load(tx, "deck/vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	var m Media
+	if r := tx.First(&m); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if m.Name != "one.mp3" || m.Type != "audio/mpeg" || m.Size != 3 {
+		t.Errorf("expected one.mp3 of audio/mpeg, got %+v", m)
+	}
+	if b, e := os.ReadFile(MediaPath(m.Hash)); e != nil || string(b) != "one" {
+		t.Errorf("expected the file copied into the media directory, got %v", e)
+	}
+
+	var a NoteAttr
+	if r := tx.Where("key = ?", "audio").First(&a); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if a.Value.Raw != MediaURL(m.Hash) {
+		t.Errorf("expected data of media URL, got %s", a.Value.Raw)
+	}
+
+	if unused, e := UnusedMedia(tx); e != nil || len(unused) != 0 {
+		t.Errorf("expected no unused media, got %v %v", unused, e)
+	}
+
+	// Exported along, next to the exported file
+	out, e := Export(tx, Filter{}, ExportOptions{
+		MediaDir: "media",
+		Dir:      filepath.Join(dir, "export"),
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(out.Note) != 1 || out.Note[0].Media["audio"] != "media/one.mp3" || out.Note[0].Data["audio"] != nil {
+		t.Errorf("expected media exported, got %+v", out.Note)
+	}
+	if b, e := os.ReadFile(filepath.Join(dir, "export", "media", "one.mp3")); e != nil || string(b) != "one" {
+		t.Errorf("expected the file exported, got %v", e)
+	}
+
+	_, e = LoadFiles(tx, []string{"deck/missing.yaml"}, LoadOptions{})
+	expected := "deck/missing.yaml:14:14: note[0].media.audio: no such file: audio/two.mp3"
+	if e == nil || e.Error() != expected {
+		t.Errorf("expected [%s], got [%v]", expected, e)
+	}
+
+	// Replaced by other data
+	if e := os.WriteFile(filepath.Join(dir, "deck", "replaced.yaml"), []byte(strings.Replace(mediaFixture, "    media:\n      audio: %s\n", "      audio: none\n", 1)), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := Load(tx, "deck/replaced.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	unused, e := UnusedMedia(tx)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(unused) != 1 || unused[0].Hash != m.Hash {
+		t.Fatalf("expected the media unused, got %+v", unused)
+	}
+
+	if e := DeleteMedia(tx, unused); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := os.Stat(MediaPath(m.Hash)); !os.IsNotExist(e) {
+		t.Errorf("expected the file deleted, got %v", e)
+	}
+}
+
+func TestLoadMediaDryRun(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"deck/vocab.yaml":    strings.Replace(mediaFixture, "%s", "audio/one.mp3", 1),
+		"deck/audio/one.mp3": "one",
+	})
+
+	dryRun := tx.Begin()
+	if _, e := Load(dryRun, "deck/vocab.yaml", LoadOptions{Diff: true}); e != nil {
+		t.Fatal(e)
+	}
+	dryRun.Rollback()
+
+	if entries, e := os.ReadDir(filepath.Join(dir, "media")); !os.IsNotExist(e) {
+		t.Errorf("expected no media copied on dry run, got %v %v", entries, e)
+	}
+}
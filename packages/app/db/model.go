@@ -22,6 +22,8 @@ type Model struct {
 	Back      string
 	Shared    string
 	Generator MapStringUnknown
+	Lang      MapStringUnknown // map[Key]Lang of note attrs, with `_` as the default
+	Hash      string           // of the generator and plugins, to skip generating again when unchanged
 }
 
 type MapStringUnknown map[string]interface{}
@@ -67,6 +69,19 @@ func (MapStringUnknown) GormDataType() string {
 	return "MapStringUnknown"
 }
 
+// FieldLang is the language of a note attr, for segmenters in config.yaml
+func (m Model) FieldLang(key string) string {
+	if lang, ok := m.Lang[key].(string); ok {
+		return lang
+	}
+
+	if lang, ok := m.Lang["_"].(string); ok {
+		return lang
+	}
+
+	return ""
+}
+
 func (Model) Tidy(tx *gorm.DB) error {
 	return nil
 }
@@ -6,20 +6,25 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
+	"github.com/rep2recall/r2r/segmenter"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
 )
 
 type Note struct {
 	ID        string
-	CreatedAt time.Time
+	CreatedAt time.Time      `gorm:"index"`
 	UpdatedAt time.Time      `gorm:"index"`
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 	Key       string         `gorm:"index:,unique"`
 	ModelID   string         `gorm:"index"`
+	Tag       SpaceSeparated `gorm:"index"`
 	Attrs     []NoteAttr     `gorm:"constraint:OnDelete:CASCADE"`
+	Hash      string         // of data as loaded, to skip generating again when unchanged
+	ModelHash string         // of the model, as of generating, see Model.Hash
 }
 
 // NoteAttr contains hooks to FTS5 model
@@ -75,8 +80,9 @@ func (j *NoteData) Set(v interface{}) error {
 	return nil
 }
 
+// GormDBDataType is TEXT, rather than JSON, whose numeric affinity would store "007" as 7
 func (NoteData) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
-	return "JSON"
+	return "TEXT"
 }
 
 // GormDataType gorm common data type
@@ -84,6 +90,21 @@ func (NoteData) GormDataType() string {
 	return "NoteData"
 }
 
+// migrateNoteData converts note_attr.value of older databases, from JSON to TEXT.
+// Run before AutoMigrate, which recreates indexes dropped with the table; and NoteFTSInit, which recreates triggers.
+func migrateNoteData(tx *gorm.DB) error {
+	var createSQL string
+	if r := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'note_attr'").Scan(&createSQL); r.Error != nil {
+		return r.Error
+	}
+
+	if !strings.Contains(createSQL, "`value` JSON") {
+		return nil
+	}
+
+	return tx.Migrator().AlterColumn(&NoteAttr{}, "Value")
+}
+
 func NoteFTSInit(tx *gorm.DB) error {
 	r := tx.Exec(`
 	CREATE VIRTUAL TABLE IF NOT EXISTS note_fts USING fts5(
@@ -114,6 +135,67 @@ func NoteFTSInit(tx *gorm.DB) error {
 	return nil
 }
 
+// NoteFTSRebuild re-derives NoteAttr.Lang from models, then rebuilds note_fts with the current segmenters.
+// Required after segmenters in config.yaml are changed, as deleting from note_fts needs the same tokens.
+func NoteFTSRebuild(tx *gorm.DB) error {
+	var models []Model
+	if r := tx.Find(&models); r.Error != nil {
+		return r.Error
+	}
+
+	toSegment := make(map[string][]string)
+	modelAttrs := make(map[string][]NoteAttr)
+
+	for _, m := range models {
+		var attrs []NoteAttr
+		if r := tx.
+			Where("note_id IN (SELECT id FROM note WHERE model_id = ?)", m.ID).
+			Select("id", "key", "value", "lang").
+			Find(&attrs); r.Error != nil {
+			return r.Error
+		}
+
+		modelAttrs[m.ID] = attrs
+		for _, a := range attrs {
+			lang := m.FieldLang(a.Key)
+			toSegment[lang] = append(toSegment[lang], a.Value.Raw)
+			if lang != a.Lang {
+				toSegment[a.Lang] = append(toSegment[a.Lang], a.Value.Raw)
+			}
+		}
+	}
+
+	// Segment in batches, before FTS triggers do it one by one
+	for lang, texts := range toSegment {
+		if e := segmenter.Prefetch(lang, texts); e != nil {
+			return e
+		}
+	}
+
+	for _, m := range models {
+		for _, a := range modelAttrs[m.ID] {
+			if lang := m.FieldLang(a.Key); lang != a.Lang {
+				if r := tx.Model(&NoteAttr{}).Where("id = ?", a.ID).Update("lang", lang); r.Error != nil {
+					return r.Error
+				}
+			}
+		}
+	}
+
+	if r := tx.Exec(`INSERT INTO note_fts(note_fts) VALUES ('delete-all')`); r.Error != nil {
+		return r.Error
+	}
+
+	if r := tx.Exec(`
+	INSERT INTO note_fts(rowid, note_id, key, value)
+	SELECT id, note_id, key, tokenize(value, lang) FROM note_attr
+	`); r.Error != nil {
+		return r.Error
+	}
+
+	return nil
+}
+
 func (Note) Tidy(tx *gorm.DB) error {
 	return nil
 }
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// loadNamespace makes IDs from names and keys, when omitted in YAML, so that they stay the same across loads
+var loadNamespace = uuid.MustParse("8c6f1b2a-4d0e-5f3a-9b7c-1e2d3f4a5b6c")
+
+// keyID is the ID of a model, template, note or card, derived from its name or key
+func keyID(kind string, key string) string {
+	return uuid.NewSHA1(loadNamespace, []byte(kind+":"+key)).String()
+}
+
+func isUUID(s string) bool {
+	_, e := uuid.Parse(s)
+	return e == nil
+}
+
+// ResolveRefs fills in omitted IDs, and references by model name, template name and note key.
+// Existing rows are matched by name or key; otherwise IDs are derived by keyID.
+// References, to the file or to the database, are checked to exist; reporting all missing ones as LoadErrors.
+func ResolveRefs(tx *gorm.DB, loadFile *LoadedStruct) error {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+
+	var errs LoadErrors
+
+	modelByName := make(map[string]string)
+	modelIDs := make(map[string]bool)
+	templateByName := make(map[string]string) // map[ModelID + "/" + Name]ID
+	templateIDs := make(map[string]bool)
+	noteByKey := make(map[string]string)
+	noteModel := make(map[string]string)
+
+	// exists checks, and caches, whether a row of table is in the database
+	exists := func(table interface{}, id string, cache map[string]bool) (bool, error) {
+		if v, ok := cache[id]; ok {
+			return v, nil
+		}
+
+		var count int64
+		if r := tx.Model(table).Where("id = ?", id).Count(&count); r.Error != nil {
+			return false, r.Error
+		}
+
+		cache[id] = count > 0
+		return cache[id], nil
+	}
+
+	for i, m := range loadFile.Model {
+		if m.ID == "" {
+			var m0 Model
+			if r := tx.Unscoped().Where("name = ?", m.Name).Select("id").Limit(1).Find(&m0); r.Error != nil {
+				return r.Error
+			}
+
+			m.ID = m0.ID
+			if m.ID == "" {
+				m.ID = keyID("model", m.Name)
+			}
+		}
+
+		if m.Name != "" {
+			modelByName[m.Name] = m.ID
+		}
+		modelIDs[m.ID] = true
+		loadFile.Model[i] = m
+	}
+
+	resolveModel := func(ref string, path string) (string, error) {
+		if isUUID(ref) {
+			ok, e := exists(&Model{}, ref, modelIDs)
+			if e != nil {
+				return "", e
+			}
+			if !ok {
+				errs = append(errs, loadFile.errorAt(path, "no such model: "+ref))
+			}
+			return ref, nil
+		}
+
+		if id, ok := modelByName[ref]; ok {
+			return id, nil
+		}
+
+		var m0 Model
+		if r := tx.Where("name = ?", ref).Select("id").Limit(1).Find(&m0); r.Error != nil {
+			return "", r.Error
+		}
+		if m0.ID == "" {
+			errs = append(errs, loadFile.errorAt(path, "no such model: "+ref))
+		}
+
+		modelByName[ref] = m0.ID
+		return m0.ID, nil
+	}
+
+	for i, t := range loadFile.Template {
+		var e error
+		if t.ModelID != "" {
+			_, e = resolveModel(t.ModelID, fmt.Sprintf("template[%d].modelId", i))
+		} else if t.Model != "" {
+			t.ModelID, e = resolveModel(t.Model, fmt.Sprintf("template[%d].model", i))
+		}
+		if e != nil {
+			return e
+		}
+
+		if t.ID == "" {
+			var t0 Template
+			if r := tx.Unscoped().Where("model_id = ? AND name = ?", t.ModelID, t.Name).Select("id").Limit(1).Find(&t0); r.Error != nil {
+				return r.Error
+			}
+
+			t.ID = t0.ID
+			if t.ID == "" {
+				t.ID = keyID("template", t.ModelID+"/"+t.Name)
+			}
+		}
+
+		if t.Name != "" {
+			templateByName[t.ModelID+"/"+t.Name] = t.ID
+		}
+		templateIDs[t.ID] = true
+		loadFile.Template[i] = t
+	}
+
+	for i, n := range loadFile.Note {
+		var e error
+		if n.ModelID != "" {
+			_, e = resolveModel(n.ModelID, fmt.Sprintf("note[%d].modelId", i))
+		} else if n.Model != "" {
+			n.ModelID, e = resolveModel(n.Model, fmt.Sprintf("note[%d].model", i))
+		}
+		if e != nil {
+			return e
+		}
+
+		if n.ID == "" {
+			// Includes notes soft-deleted by pruning, as keys are unique
+			var n0 Note
+			if r := tx.Unscoped().Where("key = ?", n.Key).Select("id").Limit(1).Find(&n0); r.Error != nil {
+				return r.Error
+			}
+
+			n.ID = n0.ID
+			if n.ID == "" {
+				n.ID = keyID("note", n.Key)
+			}
+		}
+
+		if n.Key != "" {
+			noteByKey[n.Key] = n.ID
+		}
+		noteModel[n.ID] = n.ModelID
+		loadFile.Note[i] = n
+	}
+
+	resolveNote := func(ref string, path string) (string, error) {
+		if id, ok := noteByKey[ref]; ok {
+			return id, nil
+		}
+		if _, ok := noteModel[ref]; ok {
+			return ref, nil
+		}
+
+		var n0 Note
+		if r := tx.Where("key = ? OR id = ?", ref, ref).Select("id", "model_id").Limit(1).Find(&n0); r.Error != nil {
+			return "", r.Error
+		}
+		if n0.ID == "" {
+			errs = append(errs, loadFile.errorAt(path, "no such note: "+ref))
+			return ref, nil
+		}
+
+		noteByKey[ref] = n0.ID
+		noteModel[n0.ID] = n0.ModelID
+		return n0.ID, nil
+	}
+
+	resolveTemplate := func(ref string, noteID string, path string) (string, error) {
+		if isUUID(ref) {
+			ok, e := exists(&Template{}, ref, templateIDs)
+			if e != nil {
+				return "", e
+			}
+			if !ok {
+				errs = append(errs, loadFile.errorAt(path, "no such template: "+ref))
+			}
+			return ref, nil
+		}
+
+		modelID, ok := noteModel[noteID]
+		if !ok {
+			// The note is missing, which is already reported
+			return "", nil
+		}
+
+		if id, ok := templateByName[modelID+"/"+ref]; ok {
+			return id, nil
+		}
+
+		var t0 Template
+		if r := tx.Where("model_id = ? AND name = ?", modelID, ref).Select("id").Limit(1).Find(&t0); r.Error != nil {
+			return "", r.Error
+		}
+		if t0.ID == "" {
+			errs = append(errs, loadFile.errorAt(path, "no such template: "+ref))
+		}
+
+		templateByName[modelID+"/"+ref] = t0.ID
+		return t0.ID, nil
+	}
+
+	for i, c := range loadFile.Card {
+		var e error
+		if c.NoteID != "" {
+			_, e = resolveNote(c.NoteID, fmt.Sprintf("card[%d].noteId", i))
+		} else if c.Note != "" {
+			c.NoteID, e = resolveNote(c.Note, fmt.Sprintf("card[%d].note", i))
+		}
+		if e != nil {
+			return e
+		}
+
+		if c.TemplateID != "" {
+			_, e = resolveTemplate(c.TemplateID, c.NoteID, fmt.Sprintf("card[%d].templateId", i))
+		} else if c.Template != "" {
+			c.TemplateID, e = resolveTemplate(c.Template, c.NoteID, fmt.Sprintf("card[%d].template", i))
+		}
+		if e != nil {
+			return e
+		}
+
+		if c.ID == "" {
+			var c0 Card
+			if r := tx.Unscoped().
+				Where("template_id = ? AND note_id = ?", c.TemplateID, c.NoteID).
+				Select("id").
+				Limit(1).
+				Find(&c0); r.Error != nil {
+				return r.Error
+			}
+
+			c.ID = c0.ID
+			if c.ID == "" {
+				c.ID = keyID("card", c.TemplateID+"/"+c.NoteID)
+			}
+		}
+
+		loadFile.Card[i] = c
+	}
+
+	for i, st := range loadFile.State {
+		var e error
+		if st.NoteID != "" {
+			_, e = resolveNote(st.NoteID, fmt.Sprintf("state[%d].noteId", i))
+		} else if st.Note != "" {
+			st.NoteID, e = resolveNote(st.Note, fmt.Sprintf("state[%d].note", i))
+		}
+		if e != nil {
+			return e
+		}
+
+		if st.TemplateID != "" {
+			_, e = resolveTemplate(st.TemplateID, st.NoteID, fmt.Sprintf("state[%d].templateId", i))
+		} else if st.Template != "" {
+			st.TemplateID, e = resolveTemplate(st.Template, st.NoteID, fmt.Sprintf("state[%d].template", i))
+		}
+		if e != nil {
+			return e
+		}
+
+		loadFile.State[i] = st
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NoteRevision is a previous set of attrs of a note, saved before they are overwritten
+type NoteRevision struct {
+	ID        uint
+	CreatedAt time.Time        `gorm:"index"`
+	NoteID    string           `gorm:"index"`
+	Note      Note             `gorm:"constraint:OnDelete:CASCADE"`
+	File      string           // Loaded file, which overwrote the attrs; or empty, if restored
+	Attrs     MapStringUnknown // map[Key]NoteData.Raw
+}
+
+// saveRevision saves old attrs of a note, before they are overwritten
+func saveRevision(tx *gorm.DB, noteID string, file string, old map[string]NoteAttr) error {
+	if len(old) == 0 {
+		return nil
+	}
+
+	rev := NoteRevision{
+		NoteID: noteID,
+		File:   file,
+		Attrs:  MapStringUnknown{},
+	}
+	for k, a := range old {
+		rev.Attrs[k] = a.Value.Raw
+	}
+
+	if r := tx.Create(&rev); r.Error != nil {
+		return r.Error
+	}
+
+	return nil
+}
+
+// ListRevisions lists revisions of a note, latest first
+func ListRevisions(tx *gorm.DB, noteID string) ([]NoteRevision, error) {
+	var out []NoteRevision
+	if r := tx.
+		Where("note_id = ?", noteID).
+		Order("created_at DESC, id DESC").
+		Find(&out); r.Error != nil {
+		return nil, r.Error
+	}
+
+	return out, nil
+}
+
+// RestoreRevision sets attrs of the note to those of the revision; current attrs are saved as another revision, to undo.
+// The note hash is cleared, so that generators and cards are evaluated again on the next load.
+func RestoreRevision(tx *gorm.DB, id uint) error {
+	var rev NoteRevision
+	if r := tx.Where("id = ?", id).First(&rev); r.Error != nil {
+		return r.Error
+	}
+
+	var note Note
+	if r := tx.Where("id = ?", rev.NoteID).Preload("Attrs").First(&note); r.Error != nil {
+		return r.Error
+	}
+
+	var model Model
+	if r := tx.Where("id = ?", note.ModelID).Limit(1).Find(&model); r.Error != nil {
+		return r.Error
+	}
+
+	old := make(map[string]NoteAttr)
+	for _, a := range note.Attrs {
+		old[a.Key] = a
+	}
+
+	attrs := make(map[string]string)
+	for k, v := range rev.Attrs {
+		raw, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("invalid revision attr: %s", k)
+		}
+		attrs[k] = raw
+	}
+
+	isChanged := len(old) != len(attrs)
+	for k, raw := range attrs {
+		if a, ok := old[k]; !ok || a.Value.Raw != raw {
+			isChanged = true
+		}
+	}
+	if !isChanged {
+		return nil
+	}
+
+	if e := saveRevision(tx, note.ID, "", old); e != nil {
+		return e
+	}
+
+	for k := range old {
+		if _, ok := attrs[k]; !ok {
+			if r := tx.Where("note_id = ? AND key = ?", note.ID, k).Delete(&NoteAttr{}); r.Error != nil {
+				return r.Error
+			}
+		}
+	}
+
+	for k, raw := range attrs {
+		if r := tx.Clauses(clause.OnConflict{
+			DoUpdates: clause.AssignmentColumns([]string{"value", "lang"}),
+		}).Create(&NoteAttr{
+			NoteID: note.ID,
+			Key:    k,
+			Value:  NoteData{Raw: raw},
+			Lang:   model.FieldLang(k),
+		}); r.Error != nil {
+			return r.Error
+		}
+	}
+
+	if r := tx.Model(&Note{}).Where("id = ?", note.ID).Update("hash", ""); r.Error != nil {
+		return r.Error
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNoteRevision(t *testing.T) {
+	const noteID = "7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d03"
+
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1),
+	})
+
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	write := func(word string) {
+		if e := os.WriteFile(filepath.Join(dir, "vocab.yaml"), []byte(strings.Replace(loadFixture, "%s", word, 1)), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	write("two")
+	s, e := Load(tx, "vocab.yaml", LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if strings.Join(s.Changed, " ") != noteID {
+		t.Errorf("expected note changed, got %v", s.Changed)
+	}
+
+	// Unchanged
+	s, e = Load(tx, "vocab.yaml", LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(s.Changed) != 0 {
+		t.Errorf("expected no note changed, got %v", s.Changed)
+	}
+
+	revs, e := ListRevisions(tx, noteID)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(revs) != 1 {
+		t.Fatalf("expected 1 revision, got %d", len(revs))
+	}
+	if revs[0].File != "vocab.yaml" || revs[0].Attrs["word"] != "one" {
+		t.Errorf("expected previous word from vocab.yaml, got %+v", revs[0])
+	}
+
+	if e := RestoreRevision(tx, revs[0].ID); e != nil {
+		t.Fatal(e)
+	}
+
+	var a NoteAttr
+	if r := tx.Where("note_id = ? AND key = ?", noteID, "word").First(&a); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if a.Value.Raw != "one" {
+		t.Errorf("expected word restored, got %s", a.Value.Raw)
+	}
+
+	// Restoring is also undoable
+	revs, e = ListRevisions(tx, noteID)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(revs) != 2 || revs[0].Attrs["word"] != "two" {
+		t.Errorf("expected the restored-over word as latest revision, got %+v", revs)
+	}
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// APIFile is the file of cards, whose notes or overrides are saved via API, rather than loaded; see Card.Filename
+const APIFile = "<api>"
+
+// Save saves entries of loadFile, as if loaded from APIFile; so that validation, references and cards are as in Load.
+// Data fields and tags of notes, and card overrides, are replaced; see LoadOptions.Replace.
+// Media are not accepted, as they are files of the machine, relative to a deck file.
+// loadFile is returned with IDs resolved, e.g. of entries created.
+func Save(tx *gorm.DB, loadFile LoadedStruct, opts LoadOptions) (LoadedStruct, LoadSummary, error) {
+	loadFile.file = APIFile
+	opts.Replace = true
+
+	if e := loadFile.validate(); e != nil {
+		return loadFile, LoadSummary{File: APIFile}, e
+	}
+
+	var errs LoadErrors
+	for i, n := range loadFile.Note {
+		if len(n.Media) > 0 {
+			errs = append(errs, loadFile.errorAt(fmt.Sprintf("note[%d].media", i), "media cannot be saved via API"))
+		}
+	}
+	if len(errs) > 0 {
+		return loadFile, LoadSummary{File: APIFile}, errs
+	}
+
+	if e := ResolveRefs(tx, &loadFile); e != nil {
+		return loadFile, LoadSummary{File: APIFile}, e
+	}
+
+	summary, e := LoadFrom(tx, APIFile, loadFile, opts)
+	return loadFile, summary, e
+}
+
+func GetModel(tx *gorm.DB, id string) (LoadedModelStruct, error) {
+	var m Model
+	if r := tx.Where("id = ?", id).First(&m); r.Error != nil {
+		return LoadedModelStruct{}, r.Error
+	}
+
+	return m.Loaded(), nil
+}
+
+func GetTemplate(tx *gorm.DB, id string) (LoadedTemplateStruct, error) {
+	var t Template
+	if r := tx.Where("id = ?", id).First(&t); r.Error != nil {
+		return LoadedTemplateStruct{}, r.Error
+	}
+
+	return t.Loaded(), nil
+}
+
+func GetNote(tx *gorm.DB, id string) (LoadedNoteStruct, error) {
+	var n Note
+	if r := tx.Where("id = ?", id).Preload("Attrs").First(&n); r.Error != nil {
+		return LoadedNoteStruct{}, r.Error
+	}
+
+	return n.Loaded()
+}
+
+// GetCard gets a card, with its scheduling state
+func GetCard(tx *gorm.DB, id string) (LoadedCardStruct, LoadedStateStruct, error) {
+	var c Card
+	if r := tx.Where("id = ?", id).First(&c); r.Error != nil {
+		return LoadedCardStruct{}, LoadedStateStruct{}, r.Error
+	}
+
+	card, e := c.Loaded()
+	if e != nil {
+		return card, LoadedStateStruct{}, e
+	}
+
+	state, e := c.State()
+	return card, state, e
+}
+
+// searchBy selects rows of table, with cards matching q at column; or all rows, if q is empty
+func searchBy(tx *gorm.DB, table interface{}, column string, q string) *gorm.DB {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+
+	if strings.TrimSpace(q) == "" {
+		return tx.Model(table)
+	}
+
+	return tx.Model(table).Where("id IN (?)", Search(tx.Model(&Card{}), q).Select(column))
+}
+
+// SearchModels selects models, with cards matching q; see Search
+func SearchModels(tx *gorm.DB, q string) *gorm.DB {
+	return searchBy(tx, &Model{}, "(SELECT t.model_id FROM template AS t WHERE t.id = card.template_id)", q)
+}
+
+// SearchTemplates selects templates, with cards matching q; see Search
+func SearchTemplates(tx *gorm.DB, q string) *gorm.DB {
+	return searchBy(tx, &Template{}, "card.template_id", q)
+}
+
+// SearchNotes selects notes, with cards matching q; see Search
+func SearchNotes(tx *gorm.DB, q string) *gorm.DB {
+	return searchBy(tx, &Note{}, "card.note_id", q)
+}
+
+// SearchCards selects cards matching q; see Search
+func SearchCards(tx *gorm.DB, q string) *gorm.DB {
+	return searchBy(tx, &Card{}, "card.id", q)
+}
+
+// DeleteModel soft-deletes a model, with its templates, notes and cards
+func DeleteModel(tx *gorm.DB, id string) error {
+	if e := deleteRow(tx, &Model{}, id); e != nil {
+		return e
+	}
+
+	templates := tx.Model(&Template{}).Where("model_id = ?", id).Select("id")
+	notes := tx.Model(&Note{}).Where("model_id = ?", id).Select("id")
+
+	if r := tx.Where("template_id IN (?) OR note_id IN (?)", templates, notes).Delete(&Card{}); r.Error != nil {
+		return r.Error
+	}
+	if r := tx.Where("model_id = ?", id).Delete(&Template{}); r.Error != nil {
+		return r.Error
+	}
+	if r := tx.Where("model_id = ?", id).Delete(&Note{}); r.Error != nil {
+		return r.Error
+	}
+
+	return nil
+}
+
+// DeleteTemplate soft-deletes a template, with its cards
+func DeleteTemplate(tx *gorm.DB, id string) error {
+	if e := deleteRow(tx, &Template{}, id); e != nil {
+		return e
+	}
+
+	if r := tx.Where("template_id = ?", id).Delete(&Card{}); r.Error != nil {
+		return r.Error
+	}
+
+	return nil
+}
+
+// DeleteNote soft-deletes a note, with its cards; which are restored if the note is loaded again
+func DeleteNote(tx *gorm.DB, id string) error {
+	if e := deleteRow(tx, &Note{}, id); e != nil {
+		return e
+	}
+
+	if r := tx.Where("note_id = ?", id).Delete(&Card{}); r.Error != nil {
+		return r.Error
+	}
+
+	return nil
+}
+
+// DeleteCard soft-deletes a card, with a tombstone; so that it is not made again on load
+func DeleteCard(tx *gorm.DB, id string) error {
+	if r := tx.Model(&Card{}).Where("id = ?", id).Update("tombstone", true); r.Error != nil {
+		return r.Error
+	}
+
+	return deleteRow(tx, &Card{}, id)
+}
+
+// deleteRow soft-deletes a row of table, or errors with gorm.ErrRecordNotFound
+func deleteRow(tx *gorm.DB, table interface{}, id string) error {
+	r := tx.Where("id = ?", id).Delete(table)
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestSave(t *testing.T) {
+	tx := testDB(t)
+	withUserDataDir(t, map[string]string{
+		"vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1),
+	})
+
+	if _, e := Load(tx, "vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	saved, s, e := Save(tx, LoadedStruct{
+		Note: []LoadedNoteStruct{{
+			Key:   "vocab-2",
+			Model: "vocab",
+			Data:  map[string]interface{}{"word": "two"},
+		}},
+	}, LoadOptions{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if s.Note != (LoadCount{Created: 1}) || s.Card != (LoadCount{Created: 1}) {
+		t.Errorf("expected note and its card created, got note %s, card %s", s.Note, s.Card)
+	}
+
+	id := saved.Note[0].ID
+	note, e := GetNote(tx, id)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if note.Key != "vocab-2" || note.Data["word"] != "two" {
+		t.Errorf("unexpected note %+v", note)
+	}
+
+	var notes []Note
+	if r := SearchNotes(tx, "two").Find(&notes); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if len(notes) != 1 || notes[0].ID != id {
+		t.Errorf("expected the saved note searched, got %+v", notes)
+	}
+
+	var models []Model
+	if r := SearchModels(tx, "two").Find(&models); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if len(models) != 1 || models[0].Name != "vocab" {
+		t.Errorf("expected the model of the saved note searched, got %+v", models)
+	}
+
+	if _, _, e := Save(tx, LoadedStruct{
+		Note: []LoadedNoteStruct{{Key: "vocab-3", Model: "missing", Data: map[string]interface{}{}}},
+	}, LoadOptions{}); e == nil {
+		t.Error("expected error for missing model")
+	} else {
+		var errs LoadErrors
+		if !errors.As(e, &errs) {
+			t.Errorf("expected LoadErrors, got %v", e)
+		}
+	}
+
+	if e := DeleteNote(tx, id); e != nil {
+		t.Fatal(e)
+	}
+
+	var count int64
+	if r := tx.Model(&Card{}).Where("note_id = ?", id).Count(&count); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if count != 0 {
+		t.Errorf("expected cards of the note deleted, got %d", count)
+	}
+
+	if e := DeleteNote(tx, id); !errors.Is(e, gorm.ErrRecordNotFound) {
+		t.Errorf("expected not found, got %v", e)
+	}
+
+	if e := DeleteModel(tx, "7a7e4d3e-6a2b-4f8e-9d1e-3f7c2b1a0d01"); e != nil {
+		t.Fatal(e)
+	}
+
+	for _, table := range []interface{}{&Template{}, &Note{}, &Card{}} {
+		if r := tx.Model(table).Count(&count); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+		if count != 0 {
+			t.Errorf("expected %T of the model deleted, got %d", table, count)
+		}
+	}
+}
+
+func TestSaveReplace(t *testing.T) {
+	tx := testDB(t)
+	withUserDataDir(t, map[string]string{
+		"deck/vocab.yaml":    strings.Replace(mediaFixture, "%s", "audio/one.mp3", 1),
+		"deck/audio/one.mp3": "one",
+	})
+
+	if _, e := Load(tx, "deck/vocab.yaml", LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	var n Note
+	if r := tx.Where("key = ?", "vocab-1").First(&n); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	note, e := GetNote(tx, n.ID)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// As PUT, with fields and tags of the body
+	note.Data["extra"] = "x"
+	note.Tag = []string{"a", "b"}
+	if _, _, e := Save(tx, LoadedStruct{Note: []LoadedNoteStruct{note}}, LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	delete(note.Data, "extra")
+	note.Tag = []string{"a"}
+	if _, _, e := Save(tx, LoadedStruct{Note: []LoadedNoteStruct{note}}, LoadOptions{}); e != nil {
+		t.Fatal(e)
+	}
+
+	saved, e := GetNote(tx, n.ID)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, ok := saved.Data["extra"]; ok || len(saved.Data) != 2 || strings.Join(saved.Tag, " ") != "a" {
+		t.Errorf("expected the field and tag removed, got %+v", saved)
+	}
+
+	// Media are kept, by their URLs
+	if unused, e := UnusedMedia(tx); e != nil || len(unused) != 0 {
+		t.Errorf("expected media kept, got %v %v", unused, e)
+	}
+
+	note.Media = map[string]string{"audio": "/etc/passwd"}
+	var errs LoadErrors
+	if _, _, e := Save(tx, LoadedStruct{Note: []LoadedNoteStruct{note}}, LoadOptions{}); !errors.As(e, &errs) {
+		t.Errorf("expected media rejected, got %v", e)
+	}
+
+	// Card overrides are replaced as well
+	var c Card
+	if r := tx.First(&c); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	for _, front := range []string{"custom", ""} {
+		if _, _, e := Save(tx, LoadedStruct{Card: []LoadedCardStruct{{
+			ID:         c.ID,
+			TemplateID: c.TemplateID,
+			NoteID:     c.NoteID,
+			Front:      front,
+		}}}, LoadOptions{}); e != nil {
+			t.Fatal(e)
+		}
+		if r := tx.First(&c, "id = ?", c.ID); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+		if c.Front != front {
+			t.Errorf("expected front [%s], got [%s]", front, c.Front)
+		}
+	}
+}
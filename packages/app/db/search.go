@@ -7,6 +7,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rep2recall/r2r/segmenter"
+	"github.com/rep2recall/r2r/shared"
 	"gorm.io/gorm"
 )
 
@@ -118,12 +120,66 @@ func qSearch(q string) ([]qStruct, error) {
 
 func dequote(q string) string {
 	if len(q) >= 2 && q[0] == '"' && q[len(q)-1] == '"' {
-		return q[1 : len(q)-2]
+		return q[1 : len(q)-1]
 	}
 	return q
 }
 
+// sqliteTimeFormat is understood by SQLite date and time functions
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+func ftsQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// ftsMatch makes a subquery of note IDs, with attrs of key (or any key, if empty) matching value as a phrase;
+// or, for attrs of a language with a segmenter, tokens of value as segmented by that language, also as a phrase
+func ftsMatch(tx *gorm.DB, key string, value string) *gorm.DB {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+	match := "note_attr.id IN (SELECT rowid FROM note_fts WHERE note_fts MATCH ?)"
+
+	attrs := tx.Model(&NoteAttr{})
+	if key != "" {
+		attrs = attrs.Where("note_attr.key = ?", key)
+	}
+
+	// Only languages of the attrs searched, so that segmenters of other languages are not run
+	var langs []string
+	if r := attrs.Session(&gorm.Session{}).
+		Where("note_attr.lang != ''").
+		Distinct("note_attr.lang").
+		Order("note_attr.lang").
+		Pluck("note_attr.lang", &langs); r.Error != nil {
+		shared.Logger.Println(r.Error)
+	}
+
+	cond := tx.Where(match, "value:"+ftsQuote(value))
+
+	for _, lang := range langs {
+		out, e := segmenter.TokenizeQuery(value, lang)
+		if e != nil {
+			shared.Logger.Println(e)
+			continue
+		}
+
+		tokens := strings.Fields(out)
+		if len(tokens) == 0 || strings.Join(tokens, " ") == strings.Join(strings.Fields(value), " ") {
+			continue
+		}
+
+		// As a phrase, as tokens are adjacent in value
+		cond = cond.Or(tx.Where("note_attr.lang = ?", lang).Where(match, "value:"+ftsQuote(strings.Join(tokens, " "))))
+	}
+
+	return attrs.Where(cond).Select("note_attr.note_id")
+}
+
 func Search(tx *gorm.DB, q string) *gorm.DB {
+	return search(tx, q, map[string]bool{})
+}
+
+// search is Search, with decks of saved searches already visited
+func search(tx *gorm.DB, q string, decks map[string]bool) *gorm.DB {
 	if strings.TrimSpace(q) == "" {
 		return tx.Where("TRUE")
 	}
@@ -146,6 +202,8 @@ func Search(tx *gorm.DB, q string) *gorm.DB {
 			str.Key = "card.right_streak"
 		case "wrongStreak":
 			str.Key = "card.wrong_streak"
+		case "noteAttrs":
+			str.Key = "(SELECT COUNT(*) FROM note_attr WHERE note_attr.note_id = card.note_id)"
 		}
 
 		if str.Value == "NULL" {
@@ -176,6 +234,10 @@ func Search(tx *gorm.DB, q string) *gorm.DB {
 			str.Key = "card.created_at"
 		case "updatedAt":
 			str.Key = "card.updated_at"
+		case "noteCreated":
+			str.Key = "(SELECT note.created_at FROM note WHERE note.id = card.note_id)"
+		case "noteUpdated":
+			str.Key = "(SELECT note.updated_at FROM note WHERE note.id = card.note_id)"
 		}
 
 		if str.Value == "NULL" {
@@ -212,11 +274,11 @@ func Search(tx *gorm.DB, q string) *gorm.DB {
 				time1 = time1.Add(time.Duration(unit.Nanoseconds() / 2))
 
 				return tx.
-					Where(fmt.Sprintf("strftime('%%s',%s) > strftime('%%s',?)", str.Key), time0.String()).
-					Where(fmt.Sprintf("strftime('%%s',%s) < strftime('%%s',?)", str.Key), time1.String())
+					Where(fmt.Sprintf("strftime('%%s',%s) > strftime('%%s',?)", str.Key), time0.UTC().Format(sqliteTimeFormat)).
+					Where(fmt.Sprintf("strftime('%%s',%s) < strftime('%%s',?)", str.Key), time1.UTC().Format(sqliteTimeFormat))
 			}
 
-			return tx.Where(fmt.Sprintf("strftime('%%s',%s) %s strftime('%%s',?)", str.Key, str.Op), time0.String())
+			return tx.Where(fmt.Sprintf("strftime('%%s',%s) %s strftime('%%s',?)", str.Key, str.Op), time0.UTC().Format(sqliteTimeFormat))
 		}
 
 		return tx.Where("FALSE")
@@ -229,9 +291,9 @@ func Search(tx *gorm.DB, q string) *gorm.DB {
 		}
 
 		switch str.Key {
-		case "srsLevel", "maxRight", "maxWrong", "rightStreak", "wrongStreak":
+		case "srsLevel", "maxRight", "maxWrong", "rightStreak", "wrongStreak", "noteAttrs":
 			return makeNumber(tx, str)
-		case "nextReview", "lastRight", "lastWrong", "createdAt", "updatedAt":
+		case "nextReview", "lastRight", "lastWrong", "createdAt", "updatedAt", "noteCreated", "noteUpdated":
 			return makeDate(tx, str)
 		}
 
@@ -239,7 +301,16 @@ func Search(tx *gorm.DB, q string) *gorm.DB {
 
 		switch str.Key {
 		case "tag":
-			return tx.Where("card.tag LIKE '% '||?||' %'", value)
+			nested := str.Op != "="
+			args := []interface{}{likeEscape(value)}
+			if nested {
+				args = append(args, likeEscape(value))
+			}
+
+			return tx.Where(
+				"("+tagLike("card.tag", nested)+" OR card.note_id IN (SELECT id FROM note WHERE "+tagLike("note.tag", nested)+"))",
+				append(args, args...)...,
+			)
 		case "filename":
 			return tx.Where("card.filename LIKE '%'||?||'%'", value)
 		case "is":
@@ -254,12 +325,39 @@ func Search(tx *gorm.DB, q string) *gorm.DB {
 				return tx.Where("card.next_review IS NOT NULL AND card.srs_level <= 3")
 			case "graduated":
 				return tx.Where("card.srs_level > 3")
+			case "suspended":
+				return tx.Where(tagLike("card.tag", false), likeEscape(SuspendedTag))
 			}
 			return tx.Where("FALSE")
 		case "id":
 			return tx.Where("card.id = ?", value)
-		case "key":
-			return tx.Where("card.key = ?", value)
+		case "deck":
+			sub, e := deckCards(rootTx, value, decks)
+			if e != nil {
+				return tx.Where("FALSE")
+			}
+			return tx.Where("card.id IN (?)", sub)
+		case "key", "noteKey":
+			if str.Op == "=" {
+				return tx.Where("card.note_id IN (SELECT id FROM note WHERE note.key = ?)", value)
+			} else {
+				return tx.Where("card.note_id IN (SELECT id FROM note WHERE note.key LIKE '%'||?||'%')", value)
+			}
+		case "hasMnemonic":
+			switch value {
+			case "true":
+				return tx.Where("card.mnemonic != '' AND card.mnemonic != '{}'")
+			case "false":
+				return tx.Where("(card.mnemonic IS NULL OR card.mnemonic IN ('', '{}'))")
+			}
+			return tx.Where("FALSE")
+		case "mnemonic", "front", "back":
+			column := "card." + str.Key
+			if str.Op == "=" {
+				return tx.Where(column+" = ?", value)
+			} else {
+				return tx.Where(column+" LIKE '%'||?||'%'", value)
+			}
 		case "noteId":
 			return tx.Where("card.note_id = ?", value)
 		case "templateId":
@@ -284,17 +382,19 @@ func Search(tx *gorm.DB, q string) *gorm.DB {
 			}
 		}
 
+		if value == "" {
+			return tx.Where("FALSE")
+		}
+
 		key := dequote(str.Key)
 
-		if key != "" {
+		if key != "" && str.Op == "=" {
 			return tx.Where(`card.note_id IN (
-				SELECT note_id FROM note_fts WHERE "key" = ? AND note_fts MATCH 'data:"'||?||'"'
-			)`, key, strings.ReplaceAll(value, `"`, `""`))
+				SELECT note_id FROM note_attr WHERE note_attr.key = ? AND note_attr.value = ?
+			)`, key, value)
 		}
 
-		return tx.Where(`card.note_id IN (
-			SELECT note_id FROM note_fts WHERE note_fts MATCH 'data:"'||?||'"'
-		)`, strings.ReplaceAll(value, `"`, `""`))
+		return tx.Where("card.note_id IN (?)", ftsMatch(rootTx, key, value))
 	}
 
 	arr, err := qSearch(q)
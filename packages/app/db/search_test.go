@@ -1,9 +1,18 @@
 package db
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+
+	"github.com/rep2recall/r2r/segmenter"
+	"github.com/rep2recall/r2r/shared"
+	"gorm.io/gorm"
 )
 
 func TestQSearch(t *testing.T) {
@@ -37,3 +46,418 @@ func TestQSearch(t *testing.T) {
 		t.Log(out)
 	}
 }
+
+// testDB creates a temporary database, which is removed after the test
+func testDB(t *testing.T) *gorm.DB {
+	tx, err := open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		if strings.Contains(err.Error(), "fts5") {
+			t.Fatal("SQLite is not built with FTS5, run with -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+
+	sqlDB, err := tx.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	return tx
+}
+
+func testNote(t *testing.T, tx *gorm.DB, id string, key string, modelID string, data map[string]interface{}, tags ...string) {
+	note := Note{
+		ID:      id,
+		Key:     key,
+		ModelID: modelID,
+	}
+
+	tag := map[string]bool{}
+	for _, t := range tags {
+		tag[t] = true
+	}
+	note.Tag.Set(tag)
+
+	if r := tx.Create(&note); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	for k, v := range data {
+		value := NoteData{}
+		if e := value.Set(v); e != nil {
+			t.Fatal(e)
+		}
+
+		if r := tx.Create(&NoteAttr{
+			NoteID: id,
+			Key:    k,
+			Value:  value,
+		}); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+}
+
+func searchFixture(t *testing.T) *gorm.DB {
+	tx := testDB(t)
+
+	if r := tx.Create(&Model{
+		ID:   "m1",
+		Name: "zh-vocab",
+	}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	if r := tx.Create(&Template{
+		ID:      "t1",
+		ModelID: "m1",
+		Name:    "forward",
+	}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	testNote(t, tx, "n1", "zh-发展", "m1", map[string]interface{}{
+		"chinese": "发展",
+		"english": []string{"develop", "development"},
+		"pinyin":  "fa1 zhan3",
+	})
+	testNote(t, tx, "n2", "en-running", "m1", map[string]interface{}{
+		"english": "running fast",
+		"note":    "hello world",
+	}, "grammar::verb")
+
+	c1 := Card{ID: "c1", TemplateID: "t1", NoteID: "n1", Mnemonic: "fa sounds like far"}
+	c1.Tag.Set(map[string]bool{"hsk::1": true})
+	c2 := Card{ID: "c2", TemplateID: "t1", NoteID: "n2", Mnemonic: "{}", Front: "custom front"}
+
+	for _, c := range []Card{c1, c2} {
+		if r := tx.Create(&c); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+
+	return tx
+}
+
+func searchIDs(t *testing.T, tx *gorm.DB, q string) string {
+	var cards []Card
+	if r := Search(tx, q).Find(&cards); r.Error != nil {
+		t.Fatalf("%s: %v", q, r.Error)
+	}
+
+	ids := make([]string, 0)
+	for _, c := range cards {
+		ids = append(ids, c.ID)
+	}
+	sort.Strings(ids)
+
+	return strings.Join(ids, " ")
+}
+
+func TestSearch(t *testing.T) {
+	tx := searchFixture(t)
+
+	expected := map[string]string{
+		``:                             "c1 c2",
+		`develop`:                      "c1",
+		`running`:                      "c2",
+		`run`:                          "c2",
+		`hello`:                        "c2",
+		`发展`:                           "c1",
+		`nothing`:                      "",
+		`english:develop`:              "c1",
+		`english:run`:                  "c2",
+		`note:develop`:                 "",
+		`"english":"running fast"`:     "c2",
+		`english:"fast running"`:       "",
+		`english="running fast"`:       "c2",
+		`english=running`:              "",
+		`key=en-running`:               "c2",
+		`key=running`:                  "",
+		`key:running`:                  "c2",
+		`key:zh-`:                      "c1",
+		`tag:hsk`:                      "c1",
+		`model:zh`:                     "c1 c2",
+		`model=zh`:                     "",
+		`template=forward english:run`: "c2",
+		`noteKey=en-running`:           "c2",
+		`noteAttrs:3`:                  "c1",
+		`noteAttrs<3`:                  "c2",
+		`noteCreated>-1h`:              "c1 c2",
+		`noteCreated<-1h`:              "",
+		`noteUpdated:NULL`:             "",
+		`hasMnemonic:true`:             "c1",
+		`hasMnemonic:false`:            "c2",
+		`mnemonic:far`:                 "c1",
+		`front:custom`:                 "c2",
+		`front="custom front"`:         "c2",
+		`-english:develop`:             "c2",
+		`develop ?running`:             "c1 c2",
+	}
+
+	for q, ids := range expected {
+		if out := searchIDs(t, tx, q); out != ids {
+			t.Errorf("%s: expected [%s], got [%s]", q, ids, out)
+		}
+	}
+}
+
+func TestSearchDeck(t *testing.T) {
+	tx := searchFixture(t)
+
+	for _, d := range []SavedSearch{
+		{Name: "dev", Query: "develop"},
+		{Name: "new", State: []string{"new"}},
+		{Name: "loop", Query: "deck:loop"},
+	} {
+		if r := tx.Create(&d); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+
+	expected := map[string]string{
+		`deck:dev`:         "c1",
+		`-deck:dev`:        "c2",
+		`deck:new running`: "c2",
+		`deck:missing`:     "",
+		`deck:loop`:        "",
+	}
+
+	for q, ids := range expected {
+		if out := searchIDs(t, tx, q); out != ids {
+			t.Errorf("%s: expected [%s], got [%s]", q, ids, out)
+		}
+	}
+}
+
+func TestFilterDeck(t *testing.T) {
+	tx := searchFixture(t)
+
+	if r := tx.Create(&SavedSearch{Name: "new", State: []string{"new"}}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if r := tx.Model(&Card{}).Where("id = ?", "c2").Update("srs_level", 5); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+
+	expected := map[string]string{
+		"":          "c1 c2",
+		"learning":  "c1",
+		"graduated": "c2",
+	}
+
+	for state, ids := range expected {
+		f := Filter{Deck: "new"}
+		if state != "" {
+			f.State = []string{state}
+		}
+
+		rTx, e := f.Apply(tx.Model(&Card{}))
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		var cards []Card
+		if r := rTx.Find(&cards); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+
+		out := make([]string, 0)
+		for _, c := range cards {
+			out = append(out, c.ID)
+		}
+		sort.Strings(out)
+
+		if strings.Join(out, " ") != ids {
+			t.Errorf("state %s: expected [%s], got %v", state, ids, out)
+		}
+	}
+
+	if _, e := (Filter{Deck: "missing"}).Apply(tx.Model(&Card{})); !errors.Is(e, gorm.ErrRecordNotFound) {
+		t.Errorf("expected not found for missing deck, got %v", e)
+	}
+}
+
+func TestSearchSegmenter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("segmenter script requires sh")
+	}
+
+	tx := testDB(t)
+
+	if r := tx.Create(&Model{
+		ID:   "m1",
+		Lang: MapStringUnknown{"chinese": "zh"},
+	}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if r := tx.Create(&Template{ID: "t1", ModelID: "m1"}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	testNote(t, tx, "n1", "", "m1", map[string]interface{}{
+		"chinese": "发展中国家",
+		"english": "developing country",
+	})
+	// Not segmented, as english has no language
+	testNote(t, tx, "n2", "n2", "m1", map[string]interface{}{
+		"english": "家 and 国",
+	})
+	for _, c := range []Card{
+		{ID: "c1", TemplateID: "t1", NoteID: "n1"},
+		{ID: "c2", TemplateID: "t1", NoteID: "n2"},
+	} {
+		if r := tx.Create(&c); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+
+	if out := searchIDs(t, tx, "国家"); out != "" {
+		t.Fatalf("expected no match before segmenting, got [%s]", out)
+	}
+
+	// Segments into 3-byte sequences, i.e. single characters of CJK in UTF-8
+	dir := t.TempDir()
+	if e := os.MkdirAll(filepath.Join(dir, "plugins", "app"), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(
+		filepath.Join(dir, "plugins", "app", "segment"),
+		[]byte("#!/bin/sh\nprintf '%s' \"$1\" | LC_ALL=C sed 's/.../& /g'\n"),
+		0755,
+	); e != nil {
+		t.Fatal(e)
+	}
+
+	userDataDir, segmenters := shared.UserDataDir, shared.Config.Segmenter
+	t.Cleanup(func() {
+		shared.UserDataDir, shared.Config.Segmenter = userDataDir, segmenters
+		segmenter.Reset()
+	})
+	shared.UserDataDir = dir
+	shared.Config.Segmenter = map[string]shared.SegmenterStruct{
+		"zh": {Command: []string{"segment"}},
+	}
+	segmenter.Reset()
+
+	if e := NoteFTSRebuild(tx); e != nil {
+		t.Fatal(e)
+	}
+
+	expected := map[string]string{
+		`国家`:         "c1",
+		`chinese:发展`: "c1",
+		`english:发展`: "",
+		`developing`: "c1",
+		`国外`:         "",
+	}
+
+	for q, ids := range expected {
+		if out := searchIDs(t, tx, q); out != ids {
+			t.Errorf("%s: expected [%s], got [%s]", q, ids, out)
+		}
+	}
+}
+
+func TestSearchZh(t *testing.T) {
+	tx := testDB(t)
+
+	segmenters := shared.Config.Segmenter
+	t.Cleanup(func() {
+		shared.Config.Segmenter = segmenters
+		segmenter.Reset()
+	})
+	shared.Config.Segmenter = nil
+	segmenter.Reset()
+
+	if r := tx.Create(&Model{
+		ID:   "m1",
+		Lang: MapStringUnknown{"chinese": "zh"},
+	}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if r := tx.Create(&Template{ID: "t1", ModelID: "m1"}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	testNote(t, tx, "n1", "n1", "m1", map[string]interface{}{"chinese": "发展中国家"})
+	testNote(t, tx, "n2", "n2", "m1", map[string]interface{}{"chinese": "中华人民共和国"})
+	testNote(t, tx, "n3", "n3", "m1", map[string]interface{}{"english": "家 and 国"})
+	testNote(t, tx, "n4", "n4", "m1", map[string]interface{}{"chinese": "家国情怀"})
+	testNote(t, tx, "n5", "n5", "m1", map[string]interface{}{"chinese": "国际大家"})
+	for _, c := range []Card{
+		{ID: "c1", TemplateID: "t1", NoteID: "n1"},
+		{ID: "c2", TemplateID: "t1", NoteID: "n2"},
+		{ID: "c3", TemplateID: "t1", NoteID: "n3"},
+		{ID: "c4", TemplateID: "t1", NoteID: "n4"},
+		{ID: "c5", TemplateID: "t1", NoteID: "n5"},
+	} {
+		if r := tx.Create(&c); r.Error != nil {
+			t.Fatal(r.Error)
+		}
+	}
+
+	if e := NoteFTSRebuild(tx); e != nil {
+		t.Fatal(e)
+	}
+
+	expected := map[string]string{
+		`国家`:         "c1",
+		`家国`:         "c4",
+		`华人`:         "c2",
+		`人民`:         "c2",
+		`发展中国家`:      "c1",
+		`chinese:人民`: "c2",
+		`english:国家`: "",
+		`国外`:         "",
+	}
+
+	for q, ids := range expected {
+		if out := searchIDs(t, tx, q); out != ids {
+			t.Errorf("%s: expected [%s], got [%s]", q, ids, out)
+		}
+	}
+}
+
+func TestSegmenterError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("segmenter script requires sh")
+	}
+
+	tx := testDB(t)
+
+	dir := t.TempDir()
+	if e := os.MkdirAll(filepath.Join(dir, "plugins", "app"), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(filepath.Join(dir, "plugins", "app", "segment"), []byte("#!/bin/sh\nexit 1\n"), 0755); e != nil {
+		t.Fatal(e)
+	}
+
+	userDataDir, segmenters := shared.UserDataDir, shared.Config.Segmenter
+	t.Cleanup(func() {
+		shared.UserDataDir, shared.Config.Segmenter = userDataDir, segmenters
+		segmenter.Reset()
+	})
+	shared.UserDataDir = dir
+	shared.Config.Segmenter = map[string]shared.SegmenterStruct{
+		"zh": {Command: []string{"segment"}},
+	}
+	segmenter.Reset()
+
+	if r := tx.Create(&Note{ID: "n1", Key: "n1"}); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if r := tx.Create(&NoteAttr{NoteID: "n1", Key: "chinese", Value: NoteData{Raw: "国家"}, Lang: "zh"}); r.Error == nil {
+		t.Fatal("expected the write to fail with the segmenter")
+	}
+
+	var count int64
+	if r := tx.Model(&NoteAttr{}).Count(&count); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if count != 0 {
+		t.Errorf("expected no attrs written, got %d", count)
+	}
+}
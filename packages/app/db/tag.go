@@ -0,0 +1,271 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// TagSeparator separates levels of hierarchical tags, e.g. `hsk::1`
+const TagSeparator = "::"
+
+// TagMatch tells whether tag is parent, or is nested under parent
+func TagMatch(tag string, parent string) bool {
+	return tag == parent || strings.HasPrefix(tag, parent+TagSeparator)
+}
+
+// ValidateTag rejects tags that cannot be stored in SpaceSeparated
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return errors.New("empty tag")
+	}
+
+	if strings.ContainsAny(tag, " \t\r\n") {
+		return errors.New("tag must not contain whitespace: " + tag)
+	}
+
+	for _, t := range strings.Split(tag, TagSeparator) {
+		if t == "" {
+			return errors.New("tag must not contain empty levels: " + tag)
+		}
+	}
+
+	return nil
+}
+
+// tagLike makes SQL condition for column containing tag, or tags nested under it; tag is escaped by likeEscape
+func tagLike(column string, nested bool) string {
+	if nested {
+		return "(" + column + " LIKE '% '||?||' %' ESCAPE '\\' OR " + column + " LIKE '% '||?||'" + TagSeparator + "%' ESCAPE '\\')"
+	}
+
+	return column + " LIKE '% '||?||' %' ESCAPE '\\'"
+}
+
+// likeEscape escapes wildcards of LIKE, with ESCAPE '\'
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// TagCount is a tag with the number of cards tagged, either on the card or on its note
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// ListTags lists all tags of cards and notes, with counts of cards
+func ListTags(tx *gorm.DB) ([]TagCount, error) {
+	var notes []Note
+	if r := tx.Where("tag IS NOT NULL AND tag != ''").Select("id", "tag").Find(&notes); r.Error != nil {
+		return nil, r.Error
+	}
+
+	noteTags := make(map[string]map[string]bool)
+	for _, n := range notes {
+		tag, e := n.Tag.Get()
+		if e != nil {
+			return nil, e
+		}
+		noteTags[n.ID] = tag
+	}
+
+	var cards []Card
+	if r := tx.Select("id", "note_id", "tag").Find(&cards); r.Error != nil {
+		return nil, r.Error
+	}
+
+	counts := make(map[string]int)
+	order := make([]string, 0)
+
+	for _, c := range cards {
+		tag, e := c.Tag.Get()
+		if e != nil {
+			return nil, e
+		}
+
+		for t := range noteTags[c.NoteID] {
+			tag[t] = true
+		}
+
+		for t, v := range tag {
+			if v {
+				if counts[t] == 0 {
+					order = append(order, t)
+				}
+				counts[t]++
+			}
+		}
+	}
+
+	out := make([]TagCount, 0)
+	for _, t := range order {
+		out = append(out, TagCount{
+			Tag:   t,
+			Count: counts[t],
+		})
+	}
+
+	return out, nil
+}
+
+// RenameTag renames tag `from`, and tags nested under it, on all cards and notes.
+// Renaming into an existing tag merges both.
+func RenameTag(tx *gorm.DB, from string, to string) (int64, error) {
+	if e := ValidateTag(from); e != nil {
+		return 0, e
+	}
+	if e := ValidateTag(to); e != nil {
+		return 0, e
+	}
+
+	rename := func(tag map[string]bool) {
+		renamed := make([]string, 0)
+		for t, v := range tag {
+			if v && TagMatch(t, from) {
+				delete(tag, t)
+				renamed = append(renamed, to+t[len(from):])
+			}
+		}
+
+		for _, t := range renamed {
+			tag[t] = true
+		}
+	}
+
+	var count int64
+
+	var cards []Card
+	if r := tx.Where(tagLike("tag", true), likeEscape(from), likeEscape(from)).Select("id", "tag").Find(&cards); r.Error != nil {
+		return 0, r.Error
+	}
+
+	for _, c := range cards {
+		tag, e := c.Tag.Get()
+		if e != nil {
+			return 0, e
+		}
+
+		rename(tag)
+		if e := c.Tag.Set(tag); e != nil {
+			return 0, e
+		}
+
+		if r := tx.Model(&Card{}).Where("id = ?", c.ID).Update("tag", c.Tag); r.Error != nil {
+			return 0, r.Error
+		}
+		count++
+	}
+
+	var notes []Note
+	if r := tx.Where(tagLike("tag", true), likeEscape(from), likeEscape(from)).Select("id", "tag").Find(&notes); r.Error != nil {
+		return 0, r.Error
+	}
+
+	for _, n := range notes {
+		tag, e := n.Tag.Get()
+		if e != nil {
+			return 0, e
+		}
+
+		rename(tag)
+		if e := n.Tag.Set(tag); e != nil {
+			return 0, e
+		}
+
+		if r := tx.Model(&Note{}).Where("id = ?", n.ID).Update("tag", n.Tag); r.Error != nil {
+			return 0, r.Error
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// TagOptions is the target of bulk tagging
+type TagOptions struct {
+	Add    []string
+	Remove []string // Also removes tags nested under
+	Note   bool     // Tag notes of the cards, instead of the cards themselves
+}
+
+// UpdateTags adds or removes tags on all cards matching the search query, or their notes.
+// Returns the number of cards or notes updated.
+func UpdateTags(tx *gorm.DB, q string, opts TagOptions) (int64, error) {
+	for _, t := range opts.Add {
+		if e := ValidateTag(t); e != nil {
+			return 0, e
+		}
+	}
+
+	update := func(tag map[string]bool) {
+		for _, parent := range opts.Remove {
+			for t := range tag {
+				if TagMatch(t, parent) {
+					delete(tag, t)
+				}
+			}
+		}
+
+		for _, t := range opts.Add {
+			tag[t] = true
+		}
+	}
+
+	var cards []Card
+	if r := Search(tx, q).Select("card.id", "card.note_id", "card.tag").Find(&cards); r.Error != nil {
+		return 0, r.Error
+	}
+
+	var count int64
+
+	if opts.Note {
+		noteIDs := make([]string, 0)
+		for _, c := range cards {
+			noteIDs = append(noteIDs, c.NoteID)
+		}
+
+		var notes []Note
+		if r := tx.Where("id IN ?", noteIDs).Select("id", "tag").Find(&notes); r.Error != nil {
+			return 0, r.Error
+		}
+
+		for _, n := range notes {
+			tag, e := n.Tag.Get()
+			if e != nil {
+				return 0, e
+			}
+
+			update(tag)
+			if e := n.Tag.Set(tag); e != nil {
+				return 0, e
+			}
+
+			if r := tx.Model(&Note{}).Where("id = ?", n.ID).Update("tag", n.Tag); r.Error != nil {
+				return 0, r.Error
+			}
+			count++
+		}
+
+		return count, nil
+	}
+
+	for _, c := range cards {
+		tag, e := c.Tag.Get()
+		if e != nil {
+			return 0, e
+		}
+
+		update(tag)
+		if e := c.Tag.Set(tag); e != nil {
+			return 0, e
+		}
+
+		if r := tx.Model(&Card{}).Where("id = ?", c.ID).Update("tag", c.Tag); r.Error != nil {
+			return 0, r.Error
+		}
+		count++
+	}
+
+	return count, nil
+}
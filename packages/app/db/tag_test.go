@@ -0,0 +1,110 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestRenameTag(t *testing.T) {
+	tx := searchFixture(t)
+
+	if _, e := UpdateTags(tx, "running", TagOptions{Add: []string{"hsk"}}); e != nil {
+		t.Fatal(e)
+	}
+
+	count, e := RenameTag(tx, "hsk", "zh::hsk")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 cards renamed, got %d", count)
+	}
+
+	expected := map[string]string{
+		`tag:hsk`:        "",
+		`tag:zh`:         "c1 c2",
+		`tag=zh::hsk`:    "c2",
+		`tag=zh::hsk::1`: "c1",
+	}
+
+	for q, ids := range expected {
+		if out := searchIDs(t, tx, q); out != ids {
+			t.Errorf("%s: expected [%s], got [%s]", q, ids, out)
+		}
+	}
+}
+
+func TestUpdateTags(t *testing.T) {
+	tx := searchFixture(t)
+
+	if _, e := UpdateTags(tx, "", TagOptions{Add: []string{"marked"}, Remove: []string{"hsk"}}); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := UpdateTags(tx, "develop", TagOptions{Add: []string{"noun"}, Note: true}); e != nil {
+		t.Fatal(e)
+	}
+
+	expected := map[string]string{
+		`tag:hsk`:     "",
+		`tag:marked`:  "c1 c2",
+		`tag:noun`:    "c1",
+		`tag:grammar`: "c2",
+	}
+
+	for q, ids := range expected {
+		if out := searchIDs(t, tx, q); out != ids {
+			t.Errorf("%s: expected [%s], got [%s]", q, ids, out)
+		}
+	}
+
+	tags, e := ListTags(tx)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	counts := map[string]int{}
+	for _, tc := range tags {
+		counts[tc.Tag] = tc.Count
+	}
+
+	if counts["marked"] != 2 || counts["noun"] != 1 || counts["grammar::verb"] != 1 || len(counts) != 3 {
+		t.Errorf("unexpected tag counts: %v", counts)
+	}
+
+	if _, e := UpdateTags(tx, "", TagOptions{Add: []string{"bad tag"}}); e == nil {
+		t.Error("expected error for tag with space")
+	}
+}
+
+func TestTagWildcard(t *testing.T) {
+	tx := searchFixture(t)
+
+	if _, e := UpdateTags(tx, "develop", TagOptions{Add: []string{"hsk_1"}}); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := UpdateTags(tx, "running", TagOptions{Add: []string{"hskx1", "100%"}}); e != nil {
+		t.Fatal(e)
+	}
+
+	expected := map[string]string{
+		`tag:hsk_1`: "c1",
+		`tag=hsk_1`: "c1",
+		`tag:hskx1`: "c2",
+		`tag:100%`:  "c2",
+		`tag:%`:     "",
+		`tag:_`:     "",
+	}
+
+	for q, ids := range expected {
+		if out := searchIDs(t, tx, q); out != ids {
+			t.Errorf("%s: expected [%s], got [%s]", q, ids, out)
+		}
+	}
+
+	count, e := RenameTag(tx, "hsk_1", "hsk::1")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 card renamed, got %d", count)
+	}
+}
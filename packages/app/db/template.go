@@ -20,6 +20,7 @@ type Template struct {
 	Back   string
 	Shared string
 	If     string
+	Hash   string // of `if` and plugins, to skip evaluating again when unchanged
 }
 
 func (Template) Tidy(tx *gorm.DB) error {
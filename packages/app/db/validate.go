@@ -0,0 +1,181 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadError is a problem in a file to load, at a field path like `note[12].modelId`
+type LoadError struct {
+	File    string
+	Line    int // 0 if unknown
+	Column  int
+	Field   string
+	Message string
+}
+
+func (e LoadError) Error() string {
+	out := e.File
+	if e.Line > 0 {
+		out += fmt.Sprintf(":%d:%d", e.Line, e.Column)
+	}
+	if e.Field != "" {
+		out += ": " + e.Field
+	}
+
+	return out + ": " + e.Message
+}
+
+// LoadErrors are all problems found in a file, before anything is written
+type LoadErrors []LoadError
+
+func (errs LoadErrors) Error() string {
+	out := make([]string, 0)
+	for _, e := range errs {
+		out = append(out, e.Error())
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// yamlTagName names fields in validation errors as in YAML
+func yamlTagName(fld reflect.StructField) string {
+	name := strings.SplitN(fld.Tag.Get("yaml"), ",", 2)[0]
+	if name == "" {
+		name = strings.ToLower(fld.Name)
+	}
+	return name
+}
+
+var fieldPathRegex = regexp.MustCompile(`[^.\[\]]+|\[\d+\]`)
+
+// errorAt makes LoadError at field path, positioned by the YAML node, if the file is parsed from YAML;
+// in the file and document of the entry, if included
+func (l LoadedStruct) errorAt(path string, message string) LoadError {
+	file, node := l.file, l.node
+	if i := strings.Index(path, "]"); i >= 0 {
+		if o, ok := l.origins[path[:i+1]]; ok {
+			file, node, path = o.file, o.node, o.path+path[i+1:]
+		}
+	}
+
+	out := LoadError{
+		File:    file,
+		Field:   path,
+		Message: message,
+	}
+
+	if node == nil {
+		return out
+	}
+
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	// Positions at the deepest node found, e.g. the parent of a missing field
+	for _, seg := range fieldPathRegex.FindAllString(path, -1) {
+		var next *yaml.Node
+
+		if strings.HasPrefix(seg, "[") {
+			i, _ := strconv.Atoi(seg[1 : len(seg)-1])
+			if node.Kind == yaml.SequenceNode && i < len(node.Content) {
+				next = node.Content[i]
+			}
+		} else if node.Kind == yaml.MappingNode {
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == seg {
+					next = node.Content[j+1]
+					break
+				}
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		node = next
+	}
+
+	out.Line = node.Line
+	out.Column = node.Column
+	return out
+}
+
+// validate checks fields of all entries, reporting all problems as LoadErrors
+func (l LoadedStruct) validate() error {
+	e := validate.Struct(&l)
+	if e == nil {
+		return nil
+	}
+
+	var verrs validator.ValidationErrors
+	if !errors.As(e, &verrs) {
+		return e
+	}
+
+	errs := make(LoadErrors, 0)
+	for _, fe := range verrs {
+		// Without the root struct name
+		path := fe.Namespace()
+		if i := strings.Index(path, "."); i >= 0 {
+			path = path[i+1:]
+		}
+
+		var message string
+		switch fe.Tag() {
+		case "required":
+			message = "is required"
+		case "required_without":
+			message = "is required without " + strings.ToLower(fe.Param())
+		case "uuid":
+			message = fmt.Sprintf("must be a UUID: %v", fe.Value())
+		case "tag":
+			message = fmt.Sprintf("invalid tag: %v", fe.Value())
+		case "blank-is-string":
+			message = "_ must be a string"
+		default:
+			message = fmt.Sprintf("failed on %s: %v", fe.Tag(), fe.Value())
+		}
+
+		errs = append(errs, l.errorAt(path, message))
+	}
+
+	return errs
+}
+
+var yamlLineRegex = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors converts errors of parsing YAML, with line numbers, to LoadErrors
+func yamlErrors(f string, e error) LoadErrors {
+	messages := []string{e.Error()}
+
+	var terr *yaml.TypeError
+	if errors.As(e, &terr) {
+		messages = terr.Errors
+	}
+
+	errs := make(LoadErrors, 0)
+	for _, m := range messages {
+		le := LoadError{
+			File:    f,
+			Message: m,
+		}
+
+		if match := yamlLineRegex.FindStringSubmatch(m); match != nil {
+			le.Line, _ = strconv.Atoi(match[1])
+			le.Message = match[2]
+		}
+
+		errs = append(errs, le)
+	}
+
+	return errs
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rep2recall/r2r/shared"
+	"gorm.io/gorm"
+)
+
+// watchDebounce waits for editors, which may write a file several times on save
+const watchDebounce = 300 * time.Millisecond
+
+// Watcher loads files matched by patterns again, when they change. See Watch.
+type Watcher struct {
+	watcher  *fsnotify.Watcher
+	db       *gorm.DB
+	patterns []string
+	opts     LoadOptions
+	onLoad   func([]LoadSummary, error)
+
+	mu      sync.Mutex
+	changed map[string]bool
+	timer   *time.Timer
+
+	flushMu sync.Mutex // so that loads, in a transaction each, do not overlap
+}
+
+// Watch watches files, directories and glob patterns, as in LoadFiles;
+// then, only the files changed, or including changed files, are loaded again, each time in a transaction.
+// onLoad is called after each load, e.g. to print summaries and to reload open windows.
+func Watch(db *gorm.DB, patterns []string, opts LoadOptions, onLoad func([]LoadSummary, error)) (*Watcher, error) {
+	fw, e := fsnotify.NewWatcher()
+	if e != nil {
+		return nil, e
+	}
+
+	w := &Watcher{
+		watcher:  fw,
+		db:       db,
+		patterns: patterns,
+		opts:     opts,
+		onLoad:   onLoad,
+		changed:  make(map[string]bool),
+	}
+
+	for _, p := range patterns {
+		if e := w.addPattern(p); e != nil {
+			fw.Close()
+			return nil, e
+		}
+	}
+
+	if files, e := ResolveFiles(patterns); e == nil {
+		w.includes(files)
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// addPattern watches the directory of a glob pattern or file, as editors may replace files,
+// or the directory itself recursively
+func (w *Watcher) addPattern(p string) error {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(shared.UserDataDir, p)
+	}
+
+	matches, e := filepath.Glob(p)
+	if e != nil {
+		return e
+	}
+
+	for _, m := range matches {
+		if info, e := os.Stat(m); e == nil && info.IsDir() {
+			if e := w.addDir(m); e != nil {
+				return e
+			}
+		}
+	}
+
+	// Directory part before any glob meta character
+	dir := p
+	for strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	if info, e := os.Stat(dir); e != nil || !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	return w.watcher.Add(dir)
+}
+
+// addDir watches dir and its subdirectories, skipping hidden ones as ResolveFiles
+func (w *Watcher) addDir(dir string) error {
+	return filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		return w.watcher.Add(path)
+	})
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if ev.Op&fsnotify.Create != 0 {
+				if info, e := os.Stat(ev.Name); e == nil && info.IsDir() {
+					if e := w.addDir(ev.Name); e != nil {
+						shared.Logger.Println(e)
+					}
+					continue
+				}
+			}
+
+			if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			if isLoadFile(ev.Name) {
+				w.queue(ev.Name)
+			}
+		case e, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			shared.Logger.Println(e)
+		}
+	}
+}
+
+func (w *Watcher) queue(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.changed[path] = true
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(watchDebounce, w.flush)
+}
+
+// flush loads changed files, which are still matched by the patterns.
+// Changes during a load are loaded after it, by another flush.
+func (w *Watcher) flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	changed := w.changed
+	w.changed = make(map[string]bool)
+	w.mu.Unlock()
+
+	files, e := ResolveFiles(w.patterns)
+	if e != nil {
+		w.onLoad(nil, e)
+		return
+	}
+
+	includes := w.includes(files)
+
+	toLoad := make([]string, 0)
+	for _, f := range files {
+		p := userDataPath(f)
+
+		// Files including a changed file are loaded again as well
+		isChanged := changed[p]
+		for _, inc := range includes[f] {
+			if changed[inc] {
+				isChanged = true
+			}
+		}
+
+		if isChanged {
+			if _, e := os.Stat(p); e == nil {
+				toLoad = append(toLoad, f)
+			}
+		}
+	}
+
+	if len(toLoad) == 0 {
+		return
+	}
+
+	var summaries []LoadSummary
+	e = w.db.Transaction(func(tx *gorm.DB) error {
+		var e error
+		summaries, e = LoadFiles(tx, toLoad, w.opts)
+		return e
+	})
+
+	w.onLoad(summaries, e)
+}
+
+// includes maps files to the files they include, as includedFiles;
+// watching directories of the included files, which may not be matched by the patterns
+func (w *Watcher) includes(files []string) map[string][]string {
+	out := make(map[string][]string)
+	for _, f := range files {
+		out[f] = includedFiles(f)
+
+		for _, inc := range out[f] {
+			if _, e := os.Stat(inc); e != nil {
+				continue
+			}
+			if e := w.watcher.Add(filepath.Dir(inc)); e != nil {
+				shared.Logger.Println(e)
+			}
+		}
+	}
+
+	return out
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+
+	return w.watcher.Close()
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"deck/vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1),
+		"deck/other.yaml": "",
+	})
+
+	loaded := make(chan []LoadSummary, 1)
+	w, e := Watch(tx, []string{"deck"}, LoadOptions{}, func(summaries []LoadSummary, e error) {
+		if e != nil {
+			t.Error(e)
+		}
+		loaded <- summaries
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer w.Close()
+
+	if e := os.WriteFile(filepath.Join(dir, "deck", "vocab.yaml"), []byte(strings.Replace(loadFixture, "%s", "two", 1)), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	select {
+	case summaries := <-loaded:
+		// Only the changed file is loaded
+		if len(summaries) != 1 || summaries[0].File != filepath.Join("deck", "vocab.yaml") || summaries[0].Note.Created != 1 {
+			t.Errorf("expected the changed file loaded, got %+v", summaries)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the changed file loaded")
+	}
+
+	var count int64
+	tx.Model(&Note{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected 1 note, got %d", count)
+	}
+}
+
+func TestWatchInclude(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"common/speak.yaml": `
+snippet:
+  speak: <script>window.speak = () => {}</script>
+`,
+		"deck/vocab.yaml": `
+include:
+  - ../common/speak.yaml
+model:
+  - name: vocab
+    use: [speak]
+`,
+	})
+
+	loaded := make(chan []LoadSummary, 1)
+	w, e := Watch(tx, []string{"deck"}, LoadOptions{}, func(summaries []LoadSummary, e error) {
+		if e != nil {
+			t.Error(e)
+		}
+		loaded <- summaries
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer w.Close()
+
+	// Not matched by the patterns, but included
+	if e := os.WriteFile(filepath.Join(dir, "common", "speak.yaml"), []byte(`
+snippet:
+  speak: <script>window.speak = async () => {}</script>
+`), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	select {
+	case summaries := <-loaded:
+		if len(summaries) != 1 || summaries[0].File != filepath.Join("deck", "vocab.yaml") {
+			t.Errorf("expected the including file loaded, got %+v", summaries)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the including file loaded")
+	}
+
+	var m Model
+	if r := tx.Where("name = ?", "vocab").First(&m); r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	if !strings.Contains(m.Shared, "async") {
+		t.Errorf("expected the changed snippet, got %s", m.Shared)
+	}
+}
+
+func TestWatchSerial(t *testing.T) {
+	tx := testDB(t)
+	dir := withUserDataDir(t, map[string]string{
+		"deck/vocab.yaml": strings.Replace(loadFixture, "%s", "one", 1),
+	})
+
+	var active, overlaps int32
+	loaded := make(chan bool, 2)
+	w, e := Watch(tx, []string{"deck"}, LoadOptions{}, func(summaries []LoadSummary, e error) {
+		if e != nil {
+			t.Error(e)
+		}
+
+		if atomic.AddInt32(&active, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(200 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+
+		loaded <- true
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer w.Close()
+
+	// A change during a long load
+	path := filepath.Join(dir, "deck", "vocab.yaml")
+	w.queue(path)
+	go w.flush()
+	time.Sleep(50 * time.Millisecond)
+	w.queue(path)
+	go w.flush()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-loaded:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected both changes loaded")
+		}
+	}
+
+	if overlaps > 0 {
+		t.Error("expected loads one after another")
+	}
+}
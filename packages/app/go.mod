@@ -13,7 +13,7 @@ require (
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/thatisuday/commando v1.0.4
-	golang.org/x/sys v0.0.0-20210525143221-35b2ab0089ea // indirect
+	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -26,7 +26,7 @@ require (
 	github.com/andybalholm/brotli v1.0.2 // indirect
 	github.com/klauspost/compress v1.13.4 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
-	github.com/valyala/fasthttp v1.29.0 // indirect
+	github.com/valyala/fasthttp v1.29.0
 	github.com/valyala/tcplisten v1.0.0 // indirect
 	gorm.io/gorm v1.21.15
 )
@@ -37,7 +37,12 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.8
 )
 
-require github.com/go-playground/validator v9.31.0+incompatible
+require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/fsnotify/fsnotify v1.5.1
+	github.com/go-playground/validator v9.31.0+incompatible
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/form3tech-oss/jwt-go v3.2.5+incompatible
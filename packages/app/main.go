@@ -2,9 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/patarapolw/atexit"
@@ -16,6 +22,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// errDryRun rolls back the transaction of `load --dry-run`
+var errDryRun = errors.New("dry run")
+
 func main() {
 	defer atexit.ListenPanic()
 
@@ -34,6 +43,8 @@ func main() {
 		AddFlag("mode,m", "mode to run in (app / server / proxy / quiz)", commando.String, "app").
 		AddFlag("file,f", "files to use (must be loaded first)", commando.String, ".").
 		AddFlag("filter", "keyword to filter", commando.String, ".").
+		AddFlag("deck", "saved search to use", commando.String, ".").
+		AddFlag("watch,w", "YAML files, directories or glob patterns to load again on change, comma-separated (\\, for commas in paths)", commando.String, ".").
 		AddFlag("debug", "whether to run in debug mode", commando.Bool, false).
 		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
 			debug := false
@@ -41,6 +52,8 @@ func main() {
 			mode := ""
 			files := make([]string, 0)
 			filter := ""
+			deck := ""
+			watch := make([]string, 0)
 
 			for k, v := range flags {
 				switch k {
@@ -62,11 +75,34 @@ func main() {
 					if value != "." {
 						filter = value
 					}
+				case "deck":
+					value := v.Value.(string)
+					if value != "." {
+						deck = value
+					}
+				case "watch", "w":
+					value := v.Value.(string)
+					if value != "." {
+						watch = splitPaths(value)
+					}
 				}
 			}
 
 			atexit.Listen()
 
+			// Loads, then watches, files to author decks with open windows
+			startWatch := func(s server.Server) {
+				opts := db.LoadOptions{
+					Debug: debug,
+					Port:  shared.Config.Port,
+				}
+
+				if e := loadFiles(s.DB, watch, opts); e != nil {
+					fmt.Fprintln(os.Stderr, e)
+				}
+				watchFiles(s, watch, opts)
+			}
+
 			switch mode {
 			case "server", "proxy":
 				s := server.Serve(server.ServerOptions{
@@ -75,6 +111,11 @@ func main() {
 					Port:  shared.Config.Port,
 				})
 
+				if len(watch) > 0 {
+					s.WaitUntilReady()
+					startWatch(s)
+				}
+
 				forever := make(chan bool)
 
 				log.Printf("[*] To exit press CTRL+C")
@@ -101,6 +142,10 @@ func main() {
 
 				s.WaitUntilReady()
 
+				if len(watch) > 0 {
+					startWatch(s)
+				}
+
 				rootURL := fmt.Sprintf("http://localhost:%d", shared.Config.Port)
 
 				var authOutput struct {
@@ -119,9 +164,10 @@ func main() {
 				}
 				b.AppMode(
 					rootURL+fmt.Sprintf(
-						"/quiz?q=%s&files=%s&token=%s",
+						"/quiz?q=%s&files=%s&deck=%s&token=%s",
 						url.QueryEscape(filter),
 						url.QueryEscape(fileString),
+						url.QueryEscape(deck),
 						authOutput.Token,
 					),
 					browser.WindowSize(600, 800),
@@ -140,6 +186,10 @@ func main() {
 
 				s.WaitUntilReady()
 
+				if len(watch) > 0 {
+					startWatch(s)
+				}
+
 				rootURL := fmt.Sprintf("http://localhost:%d", shared.Config.Port)
 
 				var authOutput struct {
@@ -156,7 +206,12 @@ func main() {
 				b := browser.Browser{
 					ExecPath: browserOfChoice,
 				}
-				b.AppMode(rootURL+fmt.Sprintf("/app?token=%s", authOutput.Token), browser.IsMaximized())
+				q := ""
+				if deck != "" {
+					q = fmt.Sprintf("deck:%q", deck)
+				}
+
+				b.AppMode(rootURL+fmt.Sprintf("/app?q=%s&token=%s", url.QueryEscape(q), authOutput.Token), browser.IsMaximized())
 
 				s.Close()
 			}
@@ -165,12 +220,18 @@ func main() {
 	commando.
 		Register("load").
 		SetShortDescription("load the YAML into the database and exit").
-		AddArgument("files...", "directory or YAML to scan for IDs", ""). // required
+		AddArgument("files...", "YAML, JSON, JSONL or TOML files, directories or glob patterns to load (\\, for commas in paths)", ""). // required
 		AddFlag("db,o", "database to use", commando.String, shared.Config.DB).
 		AddFlag("port,p", "port to run the server", commando.Int, shared.Config.Port).
 		AddFlag("debug", "debug mode (Chrome headful mode)", commando.Bool, false).
+		AddFlag("dry-run", "print what would change, without saving", commando.Bool, false).
+		AddFlag("prune", "remove notes and cards no longer in the files", commando.Bool, false).
+		AddFlag("watch,w", "keep running, loading files again on change", commando.Bool, false).
 		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
 			debug := false
+			dryRun := false
+			prune := false
+			watch := false
 
 			for k, v := range flags {
 				switch k {
@@ -180,9 +241,19 @@ func main() {
 					shared.Config.Port = v.Value.(int)
 				case "debug":
 					debug = v.Value.(bool)
+				case "dry-run":
+					dryRun = v.Value.(bool)
+				case "prune":
+					prune = v.Value.(bool)
+				case "watch", "w":
+					watch = v.Value.(bool)
 				}
 			}
 
+			if watch && dryRun {
+				shared.Fatalln("--dry-run cannot be used with --watch")
+			}
+
 			atexit.Listen()
 
 			s := server.Serve(server.ServerOptions{
@@ -193,26 +264,445 @@ func main() {
 
 			s.WaitUntilReady()
 
-			if e := s.DB.Transaction(func(tx *gorm.DB) error {
-				for k, v := range args {
-					if k == "files" {
-						if e := db.Load(tx, v.Value, db.LoadOptions{
-							Debug: debug,
-							Port:  shared.Config.Port,
-						}); e != nil {
-							return e
+			patterns := make([]string, 0)
+			for k, v := range args {
+				if k == "files" {
+					patterns = splitPaths(v.Value)
+				}
+			}
+
+			opts := db.LoadOptions{
+				Debug: debug,
+				Port:  shared.Config.Port,
+				Diff:  dryRun,
+				Prune: prune,
+			}
+
+			if e := loadFiles(s.DB, patterns, opts); e != nil {
+				if !watch {
+					atexit.Fatalln(e)
+				}
+				// Keeps watching, for the files to be fixed
+				fmt.Fprintln(os.Stderr, e)
+			}
+
+			if watch {
+				watchFiles(s, patterns, opts)
+
+				forever := make(chan bool)
+
+				log.Printf("[*] To exit press CTRL+C")
+				<-forever
+			}
+
+			s.Close()
+		})
+
+	commando.
+		Register("media").
+		SetShortDescription("list media of notes, or unused media").
+		AddFlag("db,o", "database to use", commando.String, shared.Config.DB).
+		AddFlag("unused", "list only media not attached to any note", commando.Bool, false).
+		AddFlag("delete", "delete unused media", commando.Bool, false).
+		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			unused := false
+			isDelete := false
+
+			for k, v := range flags {
+				switch k {
+				case "db", "o":
+					shared.Config.DB = v.Value.(string)
+				case "unused":
+					unused = v.Value.(bool)
+				case "delete":
+					isDelete = v.Value.(bool)
+				}
+			}
+
+			atexit.Listen()
+
+			tx := db.Connect()
+
+			var media []db.Media
+			if unused || isDelete {
+				m, e := db.UnusedMedia(tx)
+				if e != nil {
+					atexit.Fatalln(e)
+				}
+				media = m
+			} else if r := tx.Order("created_at").Find(&media); r.Error != nil {
+				atexit.Fatalln(r.Error)
+			}
+
+			for _, m := range media {
+				fmt.Printf("%s\t%s\t%d\t%s\n", m.Hash, m.Type, m.Size, m.Name)
+			}
+
+			if isDelete {
+				if e := db.DeleteMedia(tx, media); e != nil {
+					atexit.Fatalln(e)
+				}
+				fmt.Printf("deleted %d unused media\n", len(media))
+			}
+		})
+
+	commando.
+		Register("cards").
+		SetShortDescription("change cards matching a search, e.g. `r2r cards suspend --filter tag:hsk1`").
+		AddArgument("operation", "operation on cards ("+strings.Join(db.BulkOps, " / ")+")", "").
+		AddFlag("db,o", "database to use", commando.String, shared.Config.DB).
+		AddFlag("filter", "keyword to filter", commando.String, ".").
+		AddFlag("id", "card IDs, comma-separated, instead of filter", commando.String, ".").
+		AddFlag("tag", "tags to add or remove, comma-separated (tag / untag)", commando.String, ".").
+		AddFlag("date", "next review, as YYYY-MM-DD or RFC 3339 (reschedule)", commando.String, ".").
+		AddFlag("days", "number of days to spread overdue cards across (spread)", commando.Int, 0).
+		AddFlag("level", "SRS level (srsLevel)", commando.Int, 0).
+		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			opts := db.BulkOptions{
+				Op: args["operation"].Value,
+			}
+
+			for k, v := range flags {
+				switch k {
+				case "db", "o":
+					shared.Config.DB = v.Value.(string)
+				case "filter":
+					if s := v.Value.(string); s != "." {
+						opts.Q = s
+					}
+				case "id":
+					if s := v.Value.(string); s != "." {
+						opts.IDs = strings.Split(s, ",")
+					}
+				case "tag":
+					if s := v.Value.(string); s != "." {
+						opts.Tag = strings.Split(s, ",")
+					}
+				case "date":
+					if s := v.Value.(string); s != "." {
+						d, e := time.ParseInLocation("2006-01-02", s, time.Local)
+						if e != nil {
+							d, e = time.Parse(time.RFC3339, s)
+						}
+						if e != nil {
+							log.Fatalf("invalid date: %s\n", s)
 						}
+						opts.Date = d
 					}
+				case "days":
+					opts.Days = v.Value.(int)
+				case "level":
+					opts.SRSLevel = v.Value.(int)
 				}
+			}
 
-				return nil
+			if e := opts.Validate(); e != nil {
+				log.Fatalln(e)
+			}
+
+			atexit.Listen()
+
+			var count int64
+			if e := db.Connect().Transaction(func(tx *gorm.DB) error {
+				n, e := db.BulkCards(tx, opts)
+				count = n
+				return e
+			}); e != nil {
+				atexit.Fatalln(e)
+			}
+
+			fmt.Printf("%d cards updated\n", count)
+		})
+
+	commando.
+		Register("reindex").
+		SetShortDescription("rebuild the full-text search index, e.g. after changing segmenters").
+		AddFlag("db,o", "database to use", commando.String, shared.Config.DB).
+		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			for k, v := range flags {
+				switch k {
+				case "db", "o":
+					shared.Config.DB = v.Value.(string)
+				}
+			}
+
+			atexit.Listen()
+
+			if e := db.Connect().Transaction(func(tx *gorm.DB) error {
+				return db.NoteFTSRebuild(tx)
 			}); e != nil {
 				panic(e)
 			}
+		})
+
+	commando.
+		Register("import").
+		SetShortDescription("import from another flashcard app or spreadsheet, e.g. `r2r import anki deck.apkg`").
+		AddArgument("format", "format to import from (anki / csv)", "").
+		AddArgument("file", "file to import", "").
+		AddFlag("db,o", "database to use", commando.String, shared.Config.DB).
+		AddFlag("port,p", "port to run the server", commando.Int, shared.Config.Port).
+		AddFlag("debug", "debug mode (Chrome headful mode)", commando.Bool, false).
+		AddFlag("dry-run", "print what would change, without saving", commando.Bool, false).
+		AddFlag("model", "model ID or name of notes (csv)", commando.String, ".").
+		AddFlag("key-column", "column of note keys, by header or from 1 (csv) (default: first column)", commando.String, ".").
+		AddFlag("map", "fields to columns, e.g. english=2,pinyin=3 (csv) (default: all columns by header)", commando.String, ".").
+		AddFlag("no-header", "the first row is not header (csv)", commando.Bool, false).
+		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			debug := false
+			dryRun := false
+			csvOpts := db.CSVOptions{}
+
+			for k, v := range flags {
+				switch k {
+				case "db", "o":
+					shared.Config.DB = v.Value.(string)
+				case "port", "p":
+					shared.Config.Port = v.Value.(int)
+				case "debug":
+					debug = v.Value.(bool)
+				case "dry-run":
+					dryRun = v.Value.(bool)
+				case "model":
+					if s := v.Value.(string); s != "." {
+						csvOpts.Model = s
+					}
+				case "key-column":
+					if s := v.Value.(string); s != "." {
+						csvOpts.KeyColumn = s
+					}
+				case "map":
+					if s := v.Value.(string); s != "." {
+						csvOpts.Map = map[string]string{}
+						for _, m := range strings.Split(s, ",") {
+							kv := strings.SplitN(m, "=", 2)
+							if len(kv) != 2 {
+								log.Fatalf("invalid map: %s\n", m)
+							}
+							csvOpts.Map[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+						}
+					}
+				case "no-header":
+					csvOpts.NoHeader = v.Value.(bool)
+				}
+			}
+
+			atexit.Listen()
+
+			// Generators of models run in the browser, via the server
+			s := server.Serve(server.ServerOptions{
+				Proxy: false,
+				Debug: debug,
+				Port:  shared.Config.Port,
+			})
+
+			s.WaitUntilReady()
+
+			opts := db.LoadOptions{
+				Debug: debug,
+				Port:  shared.Config.Port,
+				Diff:  dryRun,
+			}
+
+			if e := s.DB.Transaction(func(tx *gorm.DB) error {
+				var sum db.LoadSummary
+				var e error
+
+				switch args["format"].Value {
+				case "anki":
+					sum, e = db.ImportAnki(tx, args["file"].Value, opts)
+				case "csv":
+					if csvOpts.Model == "" {
+						return errors.New("--model is required for csv")
+					}
+					sum, e = db.ImportCSV(tx, args["file"].Value, csvOpts, opts)
+				default:
+					return fmt.Errorf("unknown format: %s", args["format"].Value)
+				}
+
+				if e != nil {
+					return e
+				}
+
+				printLoadSummary(sum)
+
+				if dryRun {
+					return errDryRun
+				}
+
+				return nil
+			}); e != nil && !errors.Is(e, errDryRun) {
+				atexit.Fatalln(e)
+			}
 
 			s.Close()
 		})
 
+	commando.
+		Register("export").
+		SetShortDescription("export cards, with their notes, models and templates, as YAML, JSON or TOML to load").
+		AddFlag("db,o", "database to use", commando.String, shared.Config.DB).
+		AddFlag("filter", "keyword to filter", commando.String, ".").
+		AddFlag("deck", "saved search to use", commando.String, ".").
+		AddFlag("file,f", "file to write, with format by extension (default: stdout)", commando.String, ".").
+		AddFlag("format", "yaml / json / jsonl (notes only) / toml (default: by file extension, or yaml)", commando.String, ".").
+		AddFlag("state", "include scheduling state", commando.Bool, false).
+		SetAction(func(args map[string]commando.ArgValue, flags map[string]commando.FlagValue) {
+			filter := db.Filter{}
+			file := ""
+			format := ""
+			opts := db.ExportOptions{}
+
+			for k, v := range flags {
+				switch k {
+				case "db", "o":
+					shared.Config.DB = v.Value.(string)
+				case "filter":
+					if s := v.Value.(string); s != "." {
+						filter.Q = s
+					}
+				case "deck":
+					if s := v.Value.(string); s != "." {
+						filter.Deck = s
+					}
+				case "file", "f":
+					if s := v.Value.(string); s != "." {
+						file = s
+					}
+				case "format":
+					if s := v.Value.(string); s != "." {
+						format = s
+					}
+				case "state":
+					opts.State = v.Value.(bool)
+				}
+			}
+
+			atexit.Listen()
+
+			// Media are copied next to the file; otherwise, notes keep media URLs
+			if file != "" {
+				opts.MediaDir = "media"
+				opts.Dir = filepath.Dir(file)
+			}
+
+			out, e := db.Export(db.Connect(), filter, opts)
+			if e != nil {
+				panic(e)
+			}
+
+			if format == "" {
+				format = db.FileFormat(file)
+			}
+
+			b, e := out.Marshal(format)
+			if e != nil {
+				panic(e)
+			}
+
+			if file == "" {
+				os.Stdout.Write(b)
+				return
+			}
+
+			if e := ioutil.WriteFile(file, b, 0644); e != nil {
+				panic(e)
+			}
+		})
+
 	// parse command-line arguments
 	commando.Parse(nil)
 }
+
+// splitPaths splits comma-separated paths, as of variadic arguments; with `\,` for commas in paths
+func splitPaths(s string) []string {
+	out := make([]string, 0)
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			out = append(out, current.String())
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == ',':
+			current.WriteByte(',')
+			i++
+		case s[i] == ',':
+			flush()
+		default:
+			current.WriteByte(s[i])
+		}
+	}
+	flush()
+
+	return out
+}
+
+// loadFiles loads files in a transaction, printing summaries; rolling back with opts.Diff
+func loadFiles(database *gorm.DB, patterns []string, opts db.LoadOptions) error {
+	if e := database.Transaction(func(tx *gorm.DB) error {
+		summaries, e := db.LoadFiles(tx, patterns, opts)
+		if e != nil {
+			return e
+		}
+
+		for _, sum := range summaries {
+			printLoadSummary(sum)
+		}
+
+		if opts.Diff {
+			return errDryRun
+		}
+
+		return nil
+	}); e != nil && !errors.Is(e, errDryRun) {
+		return e
+	}
+
+	return nil
+}
+
+// watchFiles loads files again on change, printing summaries and reloading open windows
+func watchFiles(s server.Server, patterns []string, opts db.LoadOptions) {
+	w, e := db.Watch(s.DB, patterns, opts, func(summaries []db.LoadSummary, e error) {
+		if e != nil {
+			fmt.Fprintln(os.Stderr, e)
+			return
+		}
+
+		for _, sum := range summaries {
+			printLoadSummary(sum)
+		}
+
+		s.Reload()
+	})
+	if e != nil {
+		shared.Fatalln(e)
+	}
+
+	atexit.Register(func() {
+		w.Close()
+	})
+
+	log.Printf("[*] Watching %s\n", strings.Join(patterns, ", "))
+}
+
+func printLoadSummary(sum db.LoadSummary) {
+	fmt.Printf("%s\n", sum.File)
+	fmt.Printf("  model:    %s\n", sum.Model)
+	fmt.Printf("  template: %s\n", sum.Template)
+	fmt.Printf("  note:     %s\n", sum.Note)
+	fmt.Printf("  card:     %s\n", sum.Card)
+
+	if len(sum.Changed) > 0 {
+		fmt.Printf("  changed:  %s\n", strings.Join(sum.Changed, " "))
+	}
+
+	for _, c := range sum.Diff {
+		fmt.Printf("  %s\n", c)
+	}
+}
@@ -0,0 +1,44 @@
+package segmenter
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rep2recall/r2r/shared"
+)
+
+// execSegmenter runs the command once per text, with the text as the last argument
+type execSegmenter struct {
+	command []string
+}
+
+func newExecSegmenter(command []string) *execSegmenter {
+	return &execSegmenter{
+		command: command,
+	}
+}
+
+func (s *execSegmenter) Segment(texts []string) ([][]string, error) {
+	out := make([][]string, 0, len(texts))
+
+	for _, t := range texts {
+		args := append([]string{}, s.command[1:]...)
+		args = append(args, t)
+
+		dir := filepath.Join(shared.UserDataDir, "plugins", "app")
+		cmd := exec.Command(filepath.Join(dir, s.command[0]), args...)
+		cmd.Dir = dir
+		cmd.Stderr = os.Stderr
+
+		b, e := cmd.Output()
+		if e != nil {
+			return nil, e
+		}
+
+		out = append(out, strings.Fields(string(b)))
+	}
+
+	return out, nil
+}
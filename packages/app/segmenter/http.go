@@ -0,0 +1,61 @@
+package segmenter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// httpSegmenter calls a `/tokenize?q=` endpoint, as served by plugin-ja, which responds with `{"result": []}`
+type httpSegmenter struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSegmenter(u string, timeout time.Duration) *httpSegmenter {
+	return &httpSegmenter{
+		url: u,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (s *httpSegmenter) Segment(texts []string) ([][]string, error) {
+	out := make([][]string, 0, len(texts))
+
+	for _, t := range texts {
+		u, e := url.Parse(s.url)
+		if e != nil {
+			return nil, e
+		}
+
+		q := u.Query()
+		q.Set("q", t)
+		u.RawQuery = q.Encode()
+
+		res, e := s.client.Get(u.String())
+		if e != nil {
+			return nil, e
+		}
+
+		var body struct {
+			Result []string `json:"result"`
+		}
+		e = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("segmenter %s: %s", s.url, res.Status)
+		}
+		if e != nil {
+			return nil, e
+		}
+
+		out = append(out, body.Result)
+	}
+
+	return out, nil
+}
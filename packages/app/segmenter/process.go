@@ -0,0 +1,187 @@
+package segmenter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rep2recall/r2r/shared"
+)
+
+// processSegmenter keeps the command running, speaking line-delimited JSON over stdin / stdout.
+//
+//	> {"id": 1, "text": "..."}
+//	< {"id": 1, "tokens": ["...", "..."]}
+//	< {"id": 1, "error": "..."}
+//
+// A batch of texts is written at once, while reading responses, which may come in any order.
+type processSegmenter struct {
+	command []string
+	timeout time.Duration
+
+	mu        sync.Mutex // as requests and responses share stdin / stdout
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan processResponse
+	nextID    int
+}
+
+type processRequest struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+type processResponse struct {
+	ID     int      `json:"id"`
+	Tokens []string `json:"tokens"`
+	Error  string   `json:"error,omitempty"`
+}
+
+func newProcessSegmenter(command []string, timeout time.Duration) *processSegmenter {
+	return &processSegmenter{
+		command: command,
+		timeout: timeout,
+	}
+}
+
+func (s *processSegmenter) start() error {
+	dir := filepath.Join(shared.UserDataDir, "plugins", "app")
+	cmd := exec.Command(filepath.Join(dir, s.command[0]), s.command[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+
+	stdin, e := cmd.StdinPipe()
+	if e != nil {
+		return e
+	}
+
+	stdout, e := cmd.StdoutPipe()
+	if e != nil {
+		return e
+	}
+
+	if e := cmd.Start(); e != nil {
+		return e
+	}
+
+	responses := make(chan processResponse, 64)
+	go func() {
+		defer close(responses)
+
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var r processResponse
+			if e := json.Unmarshal(scanner.Bytes(), &r); e != nil {
+				shared.Logger.Printf("segmenter %s: %v\n", s.command[0], e)
+				continue
+			}
+			responses <- r
+		}
+	}()
+
+	s.cmd = cmd
+	s.stdin = stdin
+	s.responses = responses
+
+	return nil
+}
+
+func (s *processSegmenter) Segment(texts []string) ([][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil {
+		if e := s.start(); e != nil {
+			return nil, e
+		}
+	}
+
+	index := make(map[int]int)
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+
+	for i, t := range texts {
+		s.nextID++
+		index[s.nextID] = i
+
+		if e := enc.Encode(processRequest{
+			ID:   s.nextID,
+			Text: t,
+		}); e != nil {
+			return nil, e
+		}
+	}
+
+	// Written while reading, as the command may stop reading when its responses are not read
+	written := make(chan error, 1)
+	go func(stdin io.Writer) {
+		_, e := io.WriteString(stdin, buf.String())
+		written <- e
+	}(s.stdin)
+	isWritten := false
+
+	out := make([][]string, len(texts))
+	for len(index) > 0 || !isWritten {
+		select {
+		case e := <-written:
+			if e != nil {
+				s.close()
+				return nil, e
+			}
+			isWritten = true
+		case r, ok := <-s.responses:
+			if !ok {
+				s.close()
+				return nil, fmt.Errorf("segmenter %s exited", s.command[0])
+			}
+
+			i, ok := index[r.ID]
+			if !ok {
+				continue
+			}
+			delete(index, r.ID)
+
+			if r.Error != "" {
+				return nil, fmt.Errorf("segmenter %s: %s", s.command[0], r.Error)
+			}
+			out[i] = r.Tokens
+		case <-time.After(s.timeout):
+			// Restarts on the next call, rather than reading stale responses
+			s.close()
+			return nil, fmt.Errorf("segmenter %s timed out", s.command[0])
+		}
+	}
+
+	return out, nil
+}
+
+func (s *processSegmenter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.close()
+}
+
+func (s *processSegmenter) close() error {
+	if s.cmd == nil {
+		return nil
+	}
+
+	s.stdin.Close()
+	e := s.cmd.Process.Kill()
+	s.cmd.Wait()
+
+	s.cmd = nil
+	s.stdin = nil
+	s.responses = nil
+
+	return e
+}
@@ -0,0 +1,218 @@
+package segmenter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patarapolw/atexit"
+	"github.com/rep2recall/r2r/shared"
+)
+
+// Segmenter splits texts into tokens for full-text search. It may be called concurrently.
+type Segmenter interface {
+	Segment(texts []string) ([][]string, error)
+}
+
+// QuerySegmenter is a Segmenter, whose tokens of search queries differ from those of indexed texts,
+// e.g. only characters, where indexed texts have both words and their characters
+type QuerySegmenter interface {
+	SegmentQuery(texts []string) ([][]string, error)
+}
+
+// Closer is a Segmenter holding resources, e.g. a child process
+type Closer interface {
+	Close() error
+}
+
+// maxCache is the number of segmented texts to keep, before the cache is reset
+const maxCache = 50000
+
+// defaultTimeout is for a single request to a segmenter process or server
+const defaultTimeout = 10 * time.Second
+
+var natives = map[string]Segmenter{}
+
+// defaults are native segmenters of languages not in config.yaml, map[Lang]Name
+var defaults = map[string]string{}
+
+var (
+	mu         sync.Mutex
+	segmenters = map[string]Segmenter{} // map[Lang]Segmenter
+	cache      = map[string]map[string]string{}
+	cacheSize  = 0
+)
+
+func init() {
+	atexit.Register(Close)
+}
+
+// Register makes a built-in, in-process Segmenter available as `native: name` in config.yaml
+func Register(name string, s Segmenter) {
+	natives[name] = s
+}
+
+// Default makes the native Segmenter of name the default for lang, unless lang is in config.yaml
+func Default(lang string, name string) {
+	defaults[lang] = name
+}
+
+// get makes the Segmenter from config.yaml for lang, or the default; or nil if there is none
+func get(lang string) Segmenter {
+	if s, ok := segmenters[lang]; ok {
+		return s
+	}
+
+	cfg, ok := shared.Config.Segmenter[lang]
+	if !ok && defaults[lang] != "" {
+		cfg, ok = shared.SegmenterStruct{Native: defaults[lang]}, true
+	}
+
+	var s Segmenter
+	if ok {
+		timeout := defaultTimeout
+		if cfg.Timeout > 0 {
+			timeout = time.Duration(cfg.Timeout) * time.Millisecond
+		}
+
+		switch {
+		case cfg.Native != "":
+			s = natives[cfg.Native]
+			if s == nil {
+				shared.Logger.Printf("unknown native segmenter: %s\n", cfg.Native)
+			}
+		case cfg.URL != "":
+			s = newHTTPSegmenter(cfg.URL, timeout)
+		case len(cfg.Command) > 0 && cfg.Protocol == "jsonl":
+			s = newProcessSegmenter(cfg.Command, timeout)
+		case len(cfg.Command) > 0:
+			s = newExecSegmenter(cfg.Command)
+		}
+	}
+
+	segmenters[lang] = s
+	return s
+}
+
+// Prefetch segments texts in a single batch, so that later calls to Tokenize are cached
+func Prefetch(lang string, texts []string) error {
+	mu.Lock()
+	s := get(lang)
+
+	todo := make([]string, 0)
+	seen := map[string]bool{}
+	for _, t := range texts {
+		if _, ok := cache[lang][t]; !ok && !seen[t] {
+			seen[t] = true
+			todo = append(todo, t)
+		}
+	}
+	mu.Unlock()
+
+	if s == nil || len(todo) == 0 {
+		return nil
+	}
+
+	out, e := s.Segment(todo)
+	if e != nil {
+		return e
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, t := range todo {
+		setCache(lang, t, strings.Join(out[i], " "))
+	}
+
+	return nil
+}
+
+// Tokenize segments s by the segmenter of lang, as space-separated tokens; or returns s as is, if there is no segmenter.
+// A failing segmenter is an error, rather than s as is, as FTS index must be deleted with the same tokens as inserted.
+func Tokenize(s string, lang string) (string, error) {
+	mu.Lock()
+	if out, ok := cache[lang][s]; ok {
+		mu.Unlock()
+		return out, nil
+	}
+	seg := get(lang)
+	mu.Unlock()
+
+	if seg == nil {
+		return s, nil
+	}
+
+	// Not locked, so that cached texts and other languages do not wait for the segmenter
+	out, e := seg.Segment([]string{s})
+	if e != nil {
+		return "", e
+	}
+
+	r := strings.Join(out[0], " ")
+
+	mu.Lock()
+	setCache(lang, s, r)
+	mu.Unlock()
+
+	return r, nil
+}
+
+// TokenizeQuery is Tokenize for search queries, by SegmentQuery of QuerySegmenter, if implemented
+func TokenizeQuery(s string, lang string) (string, error) {
+	mu.Lock()
+	seg := get(lang)
+	mu.Unlock()
+
+	q, ok := seg.(QuerySegmenter)
+	if !ok {
+		return Tokenize(s, lang)
+	}
+
+	out, e := q.SegmentQuery([]string{s})
+	if e != nil {
+		return "", e
+	}
+
+	return strings.Join(out[0], " "), nil
+}
+
+func setCache(lang string, s string, tokens string) {
+	if cacheSize >= maxCache {
+		cache = map[string]map[string]string{}
+		cacheSize = 0
+	}
+
+	if cache[lang] == nil {
+		cache[lang] = map[string]string{}
+	}
+
+	cache[lang][s] = tokens
+	cacheSize++
+}
+
+// Reset closes all segmenters and clears the cache, so that config.yaml is read again
+func Reset() {
+	Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	segmenters = map[string]Segmenter{}
+	cache = map[string]map[string]string{}
+	cacheSize = 0
+}
+
+// Close stops all segmenter processes
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, s := range segmenters {
+		if c, ok := s.(Closer); ok {
+			if e := c.Close(); e != nil {
+				shared.Logger.Println(e)
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package segmenter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rep2recall/r2r/shared"
+)
+
+// TestHelperProcess is the segmenter process of TestProcessSegmenter, splitting texts by "/"
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("R2R_TEST_SEGMENTER") != "1" {
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	for scanner.Scan() {
+		var req processRequest
+		if e := json.Unmarshal(scanner.Bytes(), &req); e != nil {
+			os.Exit(1)
+		}
+
+		switch req.Text {
+		case "hang":
+			continue
+		case "fail":
+			enc.Encode(processResponse{ID: req.ID, Error: "failed"})
+		default:
+			enc.Encode(processResponse{ID: req.ID, Tokens: strings.Split(req.Text, "/")})
+		}
+	}
+
+	os.Exit(0)
+}
+
+func withConfig(t *testing.T, cfg map[string]shared.SegmenterStruct) {
+	userDataDir, segmenter := shared.UserDataDir, shared.Config.Segmenter
+	t.Cleanup(func() {
+		Reset()
+		shared.UserDataDir, shared.Config.Segmenter = userDataDir, segmenter
+	})
+
+	Reset()
+	shared.Config.Segmenter = cfg
+}
+
+func TestProcessSegmenter(t *testing.T) {
+	exe, e := os.Executable()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	dir := t.TempDir()
+	if e := os.MkdirAll(filepath.Join(dir, "plugins", "app"), 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Symlink(exe, filepath.Join(dir, "plugins", "app", "segment")); e != nil {
+		t.Skip(e)
+	}
+
+	t.Setenv("R2R_TEST_SEGMENTER", "1")
+	Register("upper", upperSegmenter{})
+	withConfig(t, map[string]shared.SegmenterStruct{
+		"x": {
+			Command:  []string{"segment", "-test.run=TestHelperProcess"},
+			Protocol: "jsonl",
+			Timeout:  1000,
+		},
+		"y": {Native: "upper"},
+	})
+	shared.UserDataDir = dir
+
+	if e := Prefetch("x", []string{"a/b", "c/d/e", "a/b"}); e != nil {
+		t.Fatal(e)
+	}
+	if out := cache["x"]["c/d/e"]; out != "c d e" {
+		t.Errorf("expected prefetched [c d e], got [%s]", out)
+	}
+
+	// Larger than the response buffer and the pipe buffers
+	batch := make([]string, 0)
+	for i := 0; i < 20000; i++ {
+		batch = append(batch, fmt.Sprintf("%d/%s", i, strings.Repeat("a", 100)))
+	}
+	if e := Prefetch("x", batch); e != nil {
+		t.Fatal(e)
+	}
+	if out := cache["x"][batch[19999]]; !strings.HasPrefix(out, "19999 a") {
+		t.Errorf("expected the large batch prefetched, got [%s]", out)
+	}
+
+	if out, e := Tokenize("f/g", "x"); e != nil || out != "f g" {
+		t.Errorf("expected [f g], got [%s] %v", out, e)
+	}
+
+	if _, e := Tokenize("fail", "x"); e == nil {
+		t.Error("expected error from the segmenter")
+	}
+
+	// Other languages do not wait for the segmenter
+	done := make(chan error)
+	go func() {
+		_, e := Tokenize("hang", "x")
+		done <- e
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if out, e := Tokenize("abc", "y"); e != nil || out != "ABC" {
+		t.Errorf("expected [ABC], got [%s] %v", out, e)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("waited for another segmenter: %v", time.Since(start))
+	}
+
+	if e := <-done; e == nil {
+		t.Error("expected timeout error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("timeout took too long: %v", time.Since(start))
+	}
+
+	// Restarts after the timeout
+	if out, e := Tokenize("h/i", "x"); e != nil || out != "h i" {
+		t.Errorf("expected [h i], got [%s] %v", out, e)
+	}
+}
+
+func TestHTTPSegmenter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": strings.Split(r.URL.Query().Get("q"), "/"),
+		})
+	}))
+	defer srv.Close()
+
+	withConfig(t, map[string]shared.SegmenterStruct{
+		"x": {URL: srv.URL + "/tokenize"},
+	})
+
+	if out, e := Tokenize("a/b c", "x"); e != nil || out != "a b c" {
+		t.Errorf("expected [a b c], got [%s] %v", out, e)
+	}
+}
+
+type upperSegmenter struct{}
+
+func (upperSegmenter) Segment(texts []string) ([][]string, error) {
+	out := make([][]string, 0)
+	for _, t := range texts {
+		out = append(out, []string{strings.ToUpper(t)})
+	}
+	return out, nil
+}
+
+func TestNativeSegmenter(t *testing.T) {
+	Register("upper", upperSegmenter{})
+	withConfig(t, map[string]shared.SegmenterStruct{
+		"x": {Native: "upper"},
+	})
+
+	if out, e := Tokenize("abc", "x"); e != nil || out != "ABC" {
+		t.Errorf("expected [ABC], got [%s] %v", out, e)
+	}
+
+	if out, e := Tokenize("abc", "y"); e != nil || out != "abc" {
+		t.Errorf("expected no segmenter to return [abc], got [%s] %v", out, e)
+	}
+}
+
+func TestZhSegmenter(t *testing.T) {
+	s := &zhSegmenter{}
+	out, e := s.Segment([]string{
+		"我们研究生命",
+		"发展中国家",
+		"HSK 3级，学习汉语！",
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	expected := []string{
+		"我 们 研 究 生 命 我们 研究 生命",
+		"发 展 中 国 家 发展中国家",
+		"HSK 3 级 学 习 汉 语 学习 汉语",
+	}
+
+	for i, tokens := range out {
+		if r := strings.Join(tokens, " "); r != expected[i] {
+			t.Errorf("expected [%s], got [%s]", expected[i], r)
+		}
+	}
+}
+
+func TestZhSegmentQuery(t *testing.T) {
+	s := &zhSegmenter{}
+	out, e := s.SegmentQuery([]string{"国家", "HSK 3级"})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	expected := []string{"国 家", "HSK 3 级"}
+	for i, tokens := range out {
+		if r := strings.Join(tokens, " "); r != expected[i] {
+			t.Errorf("expected [%s], got [%s]", expected[i], r)
+		}
+	}
+}
+
+func TestDefaultSegmenter(t *testing.T) {
+	withConfig(t, nil)
+
+	if out, e := Tokenize("发展中国家", "zh"); e != nil || out != "发 展 中 国 家 发展中国家" {
+		t.Errorf("expected zh by default, got [%s] %v", out, e)
+	}
+	if out, e := TokenizeQuery("国家", "zh"); e != nil || out != "国 家" {
+		t.Errorf("expected query by characters, got [%s] %v", out, e)
+	}
+
+	Reset()
+	shared.Config.Segmenter = map[string]shared.SegmenterStruct{"zh": {}}
+	if out, e := Tokenize("发展中国家", "zh"); e != nil || out != "发展中国家" {
+		t.Errorf("expected zh disabled in config.yaml, got [%s] %v", out, e)
+	}
+}
@@ -0,0 +1,209 @@
+package segmenter
+
+import (
+	_ "embed"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/rep2recall/r2r/shared"
+)
+
+//go:embed zh.txt
+var zhWords string
+
+// zhSegmenter is a dictionary-based Chinese segmenter, using bidirectional maximum matching.
+// Besides words, it also emits characters, so that a part of a word can still be searched;
+// by characters only, as a phrase, as search queries are segmented by SegmentQuery.
+// Words of single characters are not emitted again, so that a phrase of characters cannot match across them.
+type zhSegmenter struct {
+	once   sync.Once
+	words  map[string]bool
+	maxLen int // in runes
+}
+
+func init() {
+	Register("zh", &zhSegmenter{})
+	Default("zh", "zh")
+}
+
+func (s *zhSegmenter) load() {
+	s.words = make(map[string]bool)
+	s.add(zhWords)
+
+	if b, e := ioutil.ReadFile(filepath.Join(shared.UserDataDir, "plugins", "app", "zh.txt")); e == nil {
+		s.add(string(b))
+	}
+}
+
+// add adds words, one per line. Only the first field is used, so that lines like `word freq tag` also work.
+func (s *zhSegmenter) add(list string) {
+	for _, line := range strings.Split(list, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		w := []rune(fields[0])
+		s.words[string(w)] = true
+		if len(w) > s.maxLen {
+			s.maxLen = len(w)
+		}
+	}
+}
+
+func (s *zhSegmenter) Segment(texts []string) ([][]string, error) {
+	s.once.Do(s.load)
+
+	out := make([][]string, 0, len(texts))
+	for _, t := range texts {
+		out = append(out, s.segment(t))
+	}
+
+	return out, nil
+}
+
+// SegmentQuery emits only characters of Chinese, as a query word may be a part of an indexed word,
+// e.g. 国家 of 发展中国家, which is not indexed as 国家; to be searched as a phrase
+func (s *zhSegmenter) SegmentQuery(texts []string) ([][]string, error) {
+	out := make([][]string, 0, len(texts))
+	for _, t := range texts {
+		tokens := make([]string, 0)
+		for _, r := range splitHan(t) {
+			if !r.isHan {
+				tokens = append(tokens, string(r.run))
+				continue
+			}
+
+			for _, c := range r.run {
+				tokens = append(tokens, string(c))
+			}
+		}
+		out = append(out, tokens)
+	}
+
+	return out, nil
+}
+
+func (s *zhSegmenter) segment(text string) []string {
+	tokens := make([]string, 0)
+
+	for _, r := range splitHan(text) {
+		if !r.isHan {
+			tokens = append(tokens, string(r.run))
+			continue
+		}
+
+		// Characters first, adjacent as in text, so that SegmentQuery can search them as a phrase
+		for _, c := range r.run {
+			tokens = append(tokens, string(c))
+		}
+		for _, w := range s.match(r.run) {
+			if len(w) > 1 {
+				tokens = append(tokens, string(w))
+			}
+		}
+	}
+
+	return tokens
+}
+
+// zhRun is a run of either Han characters, or other letters and numbers
+type zhRun struct {
+	run   []rune
+	isHan bool
+}
+
+// splitHan splits text into runs, dropping spaces and punctuations
+func splitHan(text string) []zhRun {
+	out := make([]zhRun, 0)
+
+	var run []rune
+	isHan := false
+
+	flush := func() {
+		if len(run) > 0 {
+			out = append(out, zhRun{run: run, isHan: isHan})
+		}
+		run = nil
+	}
+
+	for _, c := range text {
+		switch {
+		case unicode.Is(unicode.Han, c):
+			if !isHan {
+				flush()
+				isHan = true
+			}
+			run = append(run, c)
+		case unicode.IsLetter(c) || unicode.IsNumber(c):
+			if isHan {
+				flush()
+				isHan = false
+			}
+			run = append(run, c)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return out
+}
+
+// match segments a run of Han characters, preferring fewer words, then fewer single characters,
+// then backward maximum matching
+func (s *zhSegmenter) match(run []rune) [][]rune {
+	forward := make([][]rune, 0)
+	for i := 0; i < len(run); {
+		l := s.longest(len(run)-i, func(l int) []rune { return run[i : i+l] })
+		forward = append(forward, run[i:i+l])
+		i += l
+	}
+
+	backward := make([][]rune, 0)
+	for j := len(run); j > 0; {
+		l := s.longest(j, func(l int) []rune { return run[j-l : j] })
+		backward = append([][]rune{run[j-l : j]}, backward...)
+		j -= l
+	}
+
+	if len(forward) != len(backward) {
+		if len(forward) < len(backward) {
+			return forward
+		}
+		return backward
+	}
+
+	if singles(forward) < singles(backward) {
+		return forward
+	}
+	return backward
+}
+
+// longest is the length of the longest word sliced by at, of at most max runes; or 1 if there is none
+func (s *zhSegmenter) longest(max int, at func(l int) []rune) int {
+	if max > s.maxLen {
+		max = s.maxLen
+	}
+
+	for l := max; l > 1; l-- {
+		if s.words[string(at(l))] {
+			return l
+		}
+	}
+
+	return 1
+}
+
+func singles(words [][]rune) int {
+	n := 0
+	for _, w := range words {
+		if len(w) == 1 {
+			n++
+		}
+	}
+	return n
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/rep2recall/r2r/db"
@@ -20,4 +22,76 @@ func (r *Router) Init() {
 
 	r.quizRouter()
 	r.cardRouter()
+	r.deckRouter()
+	r.tagRouter()
+	r.noteRouter()
+	r.modelRouter()
+	r.templateRouter()
+}
+
+// pageStruct is the query of listing endpoints, paginated and filtered by db.Search
+type pageStruct struct {
+	Q     string
+	Page  int
+	Limit int
+}
+
+// paginate finds rows of rTx into out, by page of pageStruct; and tells the count of all rows
+func (p pageStruct) paginate(rTx *gorm.DB, out interface{}) (int64, error) {
+	var count int64
+	if r := rTx.Session(&gorm.Session{}).Count(&count); r.Error != nil {
+		return 0, r.Error
+	}
+
+	if p.Limit > 0 {
+		if p.Page < 1 {
+			p.Page = 1
+		}
+		rTx = rTx.Limit(p.Limit).Offset((p.Page - 1) * p.Limit)
+	}
+
+	if r := rTx.Order("created_at").Find(out); r.Error != nil {
+		return 0, r.Error
+	}
+
+	return count, nil
+}
+
+// save saves loadFile in a transaction, see db.Save; which is rolled back with a conflict,
+// if count of the saved table is not as expected, i.e. created, or not, for POST and PUT
+func (r *Router) save(loadFile db.LoadedStruct, isCreate bool, count func(db.LoadSummary) db.LoadCount) (db.LoadedStruct, error) {
+	var out db.LoadedStruct
+
+	e := r.DB.Transaction(func(tx *gorm.DB) error {
+		saved, summary, e := db.Save(tx, loadFile, db.LoadOptions{})
+		if e != nil {
+			return e
+		}
+
+		if (count(summary).Created > 0) != isCreate {
+			return fiber.ErrConflict
+		}
+
+		out = saved
+		return nil
+	})
+
+	return out, e
+}
+
+// apiError is e as HTTP error, e.g. bad request for db.LoadErrors
+func apiError(e error) error {
+	var fe *fiber.Error
+	var errs db.LoadErrors
+
+	switch {
+	case errors.As(e, &fe):
+		return fe
+	case errors.As(e, &errs):
+		return fiber.NewError(fiber.StatusBadRequest, e.Error())
+	case errors.Is(e, gorm.ErrRecordNotFound):
+		return fiber.ErrNotFound
+	}
+
+	return fiber.NewError(fiber.StatusInternalServerError, e.Error())
 }
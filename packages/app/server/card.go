@@ -1,17 +1,75 @@
 package server
 
 import (
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/rep2recall/r2r/db"
+	"gorm.io/gorm"
 )
 
+// cardStruct is a card, with its scheduling state
+type cardStruct struct {
+	db.LoadedCardStruct
+	State db.LoadedStateStruct `json:"state"`
+}
+
+func newCardStruct(c db.Card) (cardStruct, error) {
+	card, e := c.Loaded()
+	if e != nil {
+		return cardStruct{}, e
+	}
+
+	state, e := c.State()
+	if e != nil {
+		return cardStruct{}, e
+	}
+
+	return cardStruct{
+		LoadedCardStruct: card,
+		State:            state,
+	}, nil
+}
+
 func (r *Router) cardRouter() {
 	router := r.Router.Group("/card")
 
+	router.Get("/all", func(c *fiber.Ctx) error {
+		query := pageStruct{}
+		if e := c.QueryParser(&query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var cards []db.Card
+		count, e := query.paginate(db.SearchCards(r.DB, query.Q), &cards)
+		if e != nil {
+			return apiError(e)
+		}
+
+		type outStruct struct {
+			Result []cardStruct `json:"result"`
+			Count  int64        `json:"count"`
+		}
+		out := outStruct{
+			Result: make([]cardStruct, 0),
+			Count:  count,
+		}
+		for _, c := range cards {
+			card, e := newCardStruct(c)
+			if e != nil {
+				return apiError(e)
+			}
+			out.Result = append(out.Result, card)
+		}
+
+		return c.JSON(out)
+	})
+
+	// Without side, the card itself, with its scheduling state
 	router.Get("/", func(c *fiber.Ctx) error {
 		type queryStruct struct {
 			ID   string `validate:"required,uuid"`
-			Side string `validate:"required,oneof=front back mnemonic"`
+			Side string `validate:"omitempty,oneof=front back mnemonic"`
 		}
 
 		query := new(queryStruct)
@@ -19,6 +77,18 @@ func (r *Router) cardRouter() {
 			return fiber.NewError(fiber.StatusBadRequest, e.Error())
 		}
 
+		if query.Side == "" {
+			card, state, e := db.GetCard(r.DB, query.ID)
+			if e != nil {
+				return apiError(e)
+			}
+
+			return c.JSON(cardStruct{
+				LoadedCardStruct: card,
+				State:            state,
+			})
+		}
+
 		var card db.Card
 		if rTx := r.DB.
 			Where("id = ?", query.ID).
@@ -108,6 +178,134 @@ func (r *Router) cardRouter() {
 		return c.JSON(out)
 	})
 
+	// Overrides the card of a template and a note, e.g. one not made as the template `if` is false
+	router.Post("/", func(c *fiber.Ctx) error {
+		body := db.LoadedCardStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		saved, e := r.save(db.LoadedStruct{
+			Card: []db.LoadedCardStruct{body},
+		}, true, func(s db.LoadSummary) db.LoadCount { return s.Card })
+		if e != nil {
+			return apiError(e)
+		}
+
+		card, state, e := db.GetCard(r.DB, saved.Card[0].ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(cardStruct{
+			LoadedCardStruct: card,
+			State:            state,
+		})
+	})
+
+	// Overrides the card, keeping its template, note and scheduling state
+	router.Put("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		existing, _, e := db.GetCard(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		body := db.LoadedCardStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+		body.ID = query.ID
+		body.TemplateID, body.Template = existing.TemplateID, ""
+		body.NoteID, body.Note = existing.NoteID, ""
+
+		if _, e := r.save(db.LoadedStruct{
+			Card: []db.LoadedCardStruct{body},
+		}, false, func(s db.LoadSummary) db.LoadCount { return s.Card }); e != nil {
+			return apiError(e)
+		}
+
+		card, state, e := db.GetCard(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(cardStruct{
+			LoadedCardStruct: card,
+			State:            state,
+		})
+	})
+
+	router.Delete("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		if e := r.DB.Transaction(func(tx *gorm.DB) error {
+			return db.DeleteCard(tx, query.ID)
+		}); e != nil {
+			return apiError(e)
+		}
+
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	router.Post("/bulk", func(c *fiber.Ctx) error {
+		type bodyStruct struct {
+			Q        string    `json:"q"`
+			IDs      []string  `json:"ids"`
+			Op       string    `json:"op"`
+			Tag      []string  `json:"tag"`
+			Date     time.Time `json:"date"`
+			Days     int       `json:"days"`
+			SRSLevel int       `json:"srsLevel"`
+		}
+
+		body := new(bodyStruct)
+		if e := c.BodyParser(body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		opts := db.BulkOptions{
+			Q:        body.Q,
+			IDs:      body.IDs,
+			Op:       body.Op,
+			Tag:      body.Tag,
+			Date:     body.Date,
+			Days:     body.Days,
+			SRSLevel: body.SRSLevel,
+		}
+		if e := opts.Validate(); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var count int64
+		if e := r.DB.Transaction(func(tx *gorm.DB) error {
+			n, e := db.BulkCards(tx, opts)
+			count = n
+			return e
+		}); e != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, e.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(map[string]int64{
+			"updated": count,
+		})
+	})
+
 	router.Get("/mnemonic", func(c *fiber.Ctx) error {
 		type queryStruct struct {
 			ID string `validate:"required,uuid"`
@@ -201,6 +399,64 @@ func (r *Router) cardRouter() {
 		})
 	})
 
+	router.Patch("/reset", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var card db.Card
+		if rTx := r.DB.
+			Where("id = ?", query.ID).
+			First(&card); rTx.Error != nil {
+			return apiError(rTx.Error)
+		}
+
+		if err := card.Reset(r.DB); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(map[string]interface{}{
+			"updated": true,
+		})
+	})
+
+	router.Patch("/reschedule", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID   string `validate:"required,uuid"`
+			Date string `validate:"required"` // RFC 3339
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		date, err := time.Parse(time.RFC3339, query.Date)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		var card db.Card
+		if rTx := r.DB.
+			Where("id = ?", query.ID).
+			First(&card); rTx.Error != nil {
+			return apiError(rTx.Error)
+		}
+
+		if err := card.Reschedule(r.DB, date); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(map[string]interface{}{
+			"updated": true,
+		})
+	})
+
 	router.Patch("/toggleMarked", func(c *fiber.Ctx) error {
 		type queryStruct struct {
 			ID string `validate:"required,uuid"`
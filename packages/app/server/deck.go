@@ -0,0 +1,207 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rep2recall/r2r/db"
+	"gorm.io/gorm"
+)
+
+type deckStruct struct {
+	Name  string   `json:"name"`
+	Q     string   `json:"q"`
+	Files []string `json:"files"`
+	State []string `json:"state"`
+	Limit int      `json:"limit"`
+	Order string   `json:"order"`
+}
+
+func newDeckStruct(d db.SavedSearch) deckStruct {
+	out := deckStruct{
+		Name:  d.Name,
+		Q:     d.Query,
+		Files: d.Files,
+		State: d.State,
+		Limit: d.SessionLimit,
+		Order: d.SessionOrder,
+	}
+
+	if out.Files == nil {
+		out.Files = make([]string, 0)
+	}
+	if out.State == nil {
+		out.State = make([]string, 0)
+	}
+
+	return out
+}
+
+func (d deckStruct) toSavedSearch() (db.SavedSearch, error) {
+	if d.Name == "" {
+		return db.SavedSearch{}, errors.New("name is required")
+	}
+
+	switch d.Order {
+	case "", "random", "due", "created":
+	default:
+		return db.SavedSearch{}, errors.New("order must be one of random, due, created")
+	}
+
+	if d.Limit < 0 {
+		return db.SavedSearch{}, errors.New("limit must not be negative")
+	}
+
+	return db.SavedSearch{
+		Name:         d.Name,
+		Query:        d.Q,
+		Files:        d.Files,
+		State:        d.State,
+		SessionLimit: d.Limit,
+		SessionOrder: d.Order,
+	}, nil
+}
+
+func (r *Router) deckRouter() {
+	router := r.Router.Group("/deck")
+
+	router.Get("/all", func(c *fiber.Ctx) error {
+		var decks []db.SavedSearch
+		if rTx := r.DB.Order("name").Find(&decks); rTx.Error != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, rTx.Error.Error())
+		}
+
+		type outStruct struct {
+			Result []deckStruct `json:"result"`
+		}
+		out := outStruct{
+			Result: make([]deckStruct, 0),
+		}
+		for _, d := range decks {
+			out.Result = append(out.Result, newDeckStruct(d))
+		}
+
+		return c.JSON(out)
+	})
+
+	router.Get("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			Name string `validate:"required"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var deck db.SavedSearch
+		if rTx := r.DB.Where("name = ?", query.Name).First(&deck); rTx.Error != nil {
+			if errors.Is(rTx.Error, gorm.ErrRecordNotFound) {
+				return fiber.ErrNotFound
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, rTx.Error.Error())
+		}
+
+		return c.JSON(newDeckStruct(deck))
+	})
+
+	router.Post("/", func(c *fiber.Ctx) error {
+		body := deckStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		deck, e := body.toSavedSearch()
+		if e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var count int64
+		if rTx := r.DB.Model(&db.SavedSearch{}).Where("name = ?", deck.Name).Count(&count); rTx.Error != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, rTx.Error.Error())
+		}
+		if count > 0 {
+			return fiber.ErrConflict
+		}
+
+		if rTx := r.DB.Create(&deck); rTx.Error != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, rTx.Error.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(newDeckStruct(deck))
+	})
+
+	router.Put("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			Name string `validate:"required"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		body := deckStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		deck, e := body.toSavedSearch()
+		if e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		// Renamed to another deck's name
+		if deck.Name != query.Name {
+			var count int64
+			if rTx := r.DB.Model(&db.SavedSearch{}).Where("name = ?", deck.Name).Count(&count); rTx.Error != nil {
+				return fiber.NewError(fiber.StatusInternalServerError, rTx.Error.Error())
+			}
+			if count > 0 {
+				return fiber.ErrConflict
+			}
+		}
+
+		// Select all, so that zero values are also saved
+		rTx := r.DB.
+			Model(&db.SavedSearch{}).
+			Where("name = ?", query.Name).
+			Select("name", "query", "files", "state", "session_limit", "session_order").
+			Updates(&deck)
+
+		if rTx.Error != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, rTx.Error.Error())
+		}
+
+		if rTx.RowsAffected == 0 {
+			return fiber.ErrNotFound
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(newDeckStruct(deck))
+	})
+
+	router.Delete("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			Name string `validate:"required"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		rTx := r.DB.
+			Where("name = ?", query.Name).
+			Delete(&db.SavedSearch{})
+
+		if rTx.Error != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, rTx.Error.Error())
+		}
+
+		if rTx.RowsAffected == 0 {
+			return fiber.ErrNotFound
+		}
+
+		return c.SendStatus(fiber.StatusCreated)
+	})
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// eventHub pushes server-sent events to open windows
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[chan string]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		clients: make(map[chan string]bool),
+	}
+}
+
+func (h *eventHub) subscribe() chan string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan string, 1)
+	h.clients[ch] = true
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, ch)
+}
+
+// broadcast sends event to all clients, skipping clients that haven't received the previous event
+func (h *eventHub) broadcast(event string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.clients {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// handler streams events, with pings to detect closed windows
+func (h *eventHub) handler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+
+	ch := h.subscribe()
+
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		defer h.unsubscribe(ch)
+
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case event := <-ch:
+				fmt.Fprintf(w, "event: %s\ndata: {}\n\n", event)
+			case <-ticker.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+
+			if e := w.Flush(); e != nil {
+				return
+			}
+		}
+	}))
+
+	return nil
+}
+
+// Reload tells open windows to reload, e.g. after files are loaded again
+func (r Server) Reload() {
+	if r.events != nil {
+		r.events.broadcast("reload")
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rep2recall/r2r/db"
+	"gorm.io/gorm"
+)
+
+// mediaHandler serves media by hash, for templates; without token, as in <img> and <audio>
+func mediaHandler(database *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		hash := c.Params("hash")
+		if !db.IsMediaHash(hash) {
+			return fiber.ErrNotFound
+		}
+
+		var m db.Media
+		if r := database.Where("hash = ?", hash).First(&m); r.Error != nil {
+			if errors.Is(r.Error, gorm.ErrRecordNotFound) {
+				return fiber.ErrNotFound
+			}
+			return r.Error
+		}
+
+		if e := c.SendFile(db.MediaPath(hash)); e != nil {
+			return e
+		}
+
+		// Files are named without extension; and never change for the same hash
+		c.Set(fiber.HeaderContentType, m.Type)
+		c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+		return nil
+	}
+}
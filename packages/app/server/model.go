@@ -0,0 +1,134 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/rep2recall/r2r/db"
+	"gorm.io/gorm"
+)
+
+func (r *Router) modelRouter() {
+	router := r.Router.Group("/model")
+
+	router.Get("/all", func(c *fiber.Ctx) error {
+		query := pageStruct{}
+		if e := c.QueryParser(&query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var models []db.Model
+		count, e := query.paginate(db.SearchModels(r.DB, query.Q), &models)
+		if e != nil {
+			return apiError(e)
+		}
+
+		type outStruct struct {
+			Result []db.LoadedModelStruct `json:"result"`
+			Count  int64                  `json:"count"`
+		}
+		out := outStruct{
+			Result: make([]db.LoadedModelStruct, 0),
+			Count:  count,
+		}
+		for _, m := range models {
+			out.Result = append(out.Result, m.Loaded())
+		}
+
+		return c.JSON(out)
+	})
+
+	router.Get("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		model, e := db.GetModel(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.JSON(model)
+	})
+
+	router.Post("/", func(c *fiber.Ctx) error {
+		body := db.LoadedModelStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		saved, e := r.save(db.LoadedStruct{
+			Model: []db.LoadedModelStruct{body},
+		}, true, func(s db.LoadSummary) db.LoadCount { return s.Model })
+		if e != nil {
+			return apiError(e)
+		}
+
+		model, e := db.GetModel(r.DB, saved.Model[0].ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(model)
+	})
+
+	router.Put("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		existing, e := db.GetModel(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		body := db.LoadedModelStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+		body.ID = query.ID
+		if body.Name == "" {
+			body.Name = existing.Name
+		}
+
+		if _, e := r.save(db.LoadedStruct{
+			Model: []db.LoadedModelStruct{body},
+		}, false, func(s db.LoadSummary) db.LoadCount { return s.Model }); e != nil {
+			return apiError(e)
+		}
+
+		model, e := db.GetModel(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(model)
+	})
+
+	router.Delete("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		if e := r.DB.Transaction(func(tx *gorm.DB) error {
+			return db.DeleteModel(tx, query.ID)
+		}); e != nil {
+			return apiError(e)
+		}
+
+		return c.SendStatus(fiber.StatusCreated)
+	})
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rep2recall/r2r/db"
+	"gorm.io/gorm"
+)
+
+func (r *Router) noteRouter() {
+	router := r.Router.Group("/note")
+
+	router.Get("/all", func(c *fiber.Ctx) error {
+		query := pageStruct{}
+		if e := c.QueryParser(&query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var notes []db.Note
+		count, e := query.paginate(db.SearchNotes(r.DB, query.Q).Preload("Attrs"), &notes)
+		if e != nil {
+			return apiError(e)
+		}
+
+		type outStruct struct {
+			Result []db.LoadedNoteStruct `json:"result"`
+			Count  int64                 `json:"count"`
+		}
+		out := outStruct{
+			Result: make([]db.LoadedNoteStruct, 0),
+			Count:  count,
+		}
+		for _, n := range notes {
+			note, e := n.Loaded()
+			if e != nil {
+				return apiError(e)
+			}
+			out.Result = append(out.Result, note)
+		}
+
+		return c.JSON(out)
+	})
+
+	router.Get("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		note, e := db.GetNote(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.JSON(note)
+	})
+
+	// Cards are made from templates of the model, as on load
+	router.Post("/", func(c *fiber.Ctx) error {
+		body := db.LoadedNoteStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		saved, e := r.save(db.LoadedStruct{
+			Note: []db.LoadedNoteStruct{body},
+		}, true, func(s db.LoadSummary) db.LoadCount { return s.Note })
+		if e != nil {
+			return apiError(e)
+		}
+
+		note, e := db.GetNote(r.DB, saved.Note[0].ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(note)
+	})
+
+	router.Put("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		existing, e := db.GetNote(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		body := db.LoadedNoteStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+		body.ID = query.ID
+		if body.Key == "" {
+			body.Key = existing.Key
+		}
+		if body.ModelID == "" && body.Model == "" {
+			body.ModelID = existing.ModelID
+		}
+
+		if _, e := r.save(db.LoadedStruct{
+			Note: []db.LoadedNoteStruct{body},
+		}, false, func(s db.LoadSummary) db.LoadCount { return s.Note }); e != nil {
+			return apiError(e)
+		}
+
+		note, e := db.GetNote(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(note)
+	})
+
+	router.Delete("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		if e := r.DB.Transaction(func(tx *gorm.DB) error {
+			return db.DeleteNote(tx, query.ID)
+		}); e != nil {
+			return apiError(e)
+		}
+
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	router.Get("/revisions", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		revs, e := db.ListRevisions(r.DB, query.ID)
+		if e != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, e.Error())
+		}
+
+		type revisionStruct struct {
+			ID        uint                   `json:"id"`
+			CreatedAt time.Time              `json:"createdAt"`
+			File      string                 `json:"file"`
+			Data      map[string]interface{} `json:"data"`
+		}
+
+		type outStruct struct {
+			Result []revisionStruct `json:"result"`
+		}
+		out := outStruct{
+			Result: make([]revisionStruct, 0),
+		}
+		for _, rev := range revs {
+			data := make(map[string]interface{})
+			for k, v := range rev.Attrs {
+				raw, _ := v.(string)
+				value, e := db.NoteData{Raw: raw}.Get()
+				if e != nil {
+					return fiber.NewError(fiber.StatusInternalServerError, e.Error())
+				}
+				data[k] = value
+			}
+
+			out.Result = append(out.Result, revisionStruct{
+				ID:        rev.ID,
+				CreatedAt: rev.CreatedAt,
+				File:      rev.File,
+				Data:      data,
+			})
+		}
+
+		return c.JSON(out)
+	})
+
+	router.Patch("/restore", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID uint `validate:"required"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		if e := r.DB.Transaction(func(tx *gorm.DB) error {
+			return db.RestoreRevision(tx, query.ID)
+		}); e != nil {
+			if errors.Is(e, gorm.ErrRecordNotFound) {
+				return fiber.ErrNotFound
+			}
+			return fiber.NewError(fiber.StatusInternalServerError, e.Error())
+		}
+
+		return c.SendStatus(fiber.StatusCreated)
+	})
+}
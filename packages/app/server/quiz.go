@@ -2,7 +2,6 @@ package server
 
 import (
 	"encoding/json"
-	"math/rand"
 	"strings"
 	"time"
 
@@ -71,13 +70,16 @@ func (r *Router) quizRouter() {
 
 		cards, err := getCard(r.DB, query)
 		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+			return apiError(err)
 		}
 
-		rand.Seed(time.Now().UnixNano())
-		rand.Shuffle(len(cards), func(i, j int) {
-			cards[i], cards[j] = cards[j], cards[i]
-		})
+		deck := db.SavedSearch{}
+		if query.Deck != "" {
+			if rTx := r.DB.Where("name = ?", query.Deck).First(&deck); rTx.Error != nil {
+				return apiError(rTx.Error)
+			}
+		}
+		cards = deck.Arrange(cards)
 
 		sess, err := r.Store.Get(c)
 		if err != nil {
@@ -107,7 +109,7 @@ func (r *Router) quizRouter() {
 
 		cards, err := getCard(r.DB, query)
 		if err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+			return apiError(err)
 		}
 
 		type outStruct struct {
@@ -185,81 +187,34 @@ type getCardStruct struct {
 	Q     string
 	State string
 	Files string
+	Deck  string
 }
 
 func getCard(tx *gorm.DB, query getCardStruct) ([]db.Card, error) {
-	rootTx := tx
+	filter := db.Filter{
+		Q:    query.Q,
+		Deck: query.Deck,
+	}
 
 	if query.Files != "" {
-		files := make([]string, 0)
-		if e := json.Unmarshal([]byte(query.Files), &files); e != nil {
+		if e := json.Unmarshal([]byte(query.Files), &filter.Files); e != nil {
 			return nil, e
 		}
-
-		for _, f := range files {
-			str, e := db.LoadStruct(f)
-			if e != nil {
-				return nil, e
-			}
-
-			var cond *gorm.DB
-
-			noteIDs := make([]string, 0)
-			for _, n := range str.Note {
-				noteIDs = append(noteIDs, n.ID)
-			}
-
-			if len(noteIDs) > 0 {
-				cond = tx.Where("note_id IN ?", noteIDs)
-			}
-
-			cardIDs := make([]string, 0)
-			for _, c := range str.Card {
-				cardIDs = append(cardIDs, c.ID)
-			}
-
-			if len(cardIDs) > 0 {
-				if cond != nil {
-					cond = cond.Or(tx.Where("id IN ?", cardIDs))
-				} else {
-					cond = tx.Where("id IN ?", cardIDs)
-				}
-			}
-
-			if cond != nil {
-				rootTx = rootTx.Where(cond)
-			}
-		}
 	}
 
-	rTx := db.Search(rootTx, query.Q)
-
-	rState := tx.Where("FALSE")
+	// Intersected with state of the saved search
 	if len(query.State) > 0 {
-		for _, s := range strings.Split(query.State, ",") {
-			switch s {
-			case "new":
-				rState = rState.Or("card.next_review IS NULL")
-			case "learning":
-				rState = rState.Or("card.srs_level <= 3")
-			case "graduated":
-				rState = rState.Or("card.srs_level > 3")
-			case "leech":
-				rState = rState.Or("card.wrong_streak > 1")
-			}
-		}
+		filter.State = strings.Split(query.State, ",")
+	}
 
-		for _, s := range strings.Split(query.State, ",") {
-			switch s {
-			case "due":
-				rState = rState.Where("strftime('%s', card.next_review) < strftime('%s', 'now')")
-			}
-		}
+	rTx, e := filter.Apply(tx)
+	if e != nil {
+		return nil, e
 	}
+	rTx = rTx.Where("card.id NOT IN (?)", db.SearchCards(tx, "is:suspended").Select("card.id"))
 
 	var cards []db.Card
-	if rTx := rTx.Where(rState).
-		Find(&cards); rTx.Error != nil {
+	if rTx := rTx.Find(&cards); rTx.Error != nil {
 		return nil, rTx.Error
 	}
 
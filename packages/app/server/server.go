@@ -29,6 +29,7 @@ type Server struct {
 	Engine     *fiber.App
 	Server     net.Listener
 	port       int
+	events     *eventHub
 	SubCommand []*exec.Cmd
 }
 
@@ -44,6 +45,7 @@ func Serve(opts ServerOptions) Server {
 	r := Server{
 		Engine: app,
 		port:   opts.Port,
+		events: newEventHub(),
 	}
 
 	app.Static("/", filepath.Join(shared.ExecDir, "public"))
@@ -110,6 +112,16 @@ func Serve(opts ServerOptions) Server {
 		},
 	)
 
+	// EventSource cannot set headers, so the token is in the query
+	apiSrv.Get(
+		"/events",
+		jwtware.New(jwtware.Config{
+			SigningKey:  bootRand,
+			TokenLookup: "query:token",
+		}),
+		r.events.handler,
+	)
+
 	proxyRouter := app.Group(("/proxy"))
 
 	for k, v := range shared.Config.Proxy {
@@ -157,6 +169,8 @@ func Serve(opts ServerOptions) Server {
 
 	r.DB = apiRouter.DB
 
+	app.Get("/media/:hash", mediaHandler(r.DB))
+
 	shared.Logger.Printf("Server running at http://localhost:%d\n", opts.Port)
 
 	listener, e := net.Listen("tcp", fmt.Sprintf(":%d", opts.Port))
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rep2recall/r2r/db"
+	"gorm.io/gorm"
+)
+
+func (r *Router) tagRouter() {
+	router := r.Router.Group("/tag")
+
+	router.Get("/all", func(c *fiber.Ctx) error {
+		tags, err := db.ListTags(r.DB)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+
+		sort.Slice(tags, func(i, j int) bool {
+			return tags[i].Tag < tags[j].Tag
+		})
+
+		type tagStruct struct {
+			Tag   string `json:"tag"`
+			Count int    `json:"count"`
+		}
+
+		type outStruct struct {
+			Result []tagStruct `json:"result"`
+		}
+		out := outStruct{
+			Result: make([]tagStruct, 0),
+		}
+		for _, t := range tags {
+			out.Result = append(out.Result, tagStruct{
+				Tag:   t.Tag,
+				Count: t.Count,
+			})
+		}
+
+		return c.JSON(out)
+	})
+
+	router.Patch("/rename", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			From string `validate:"required"`
+			To   string `validate:"required"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		if e := db.ValidateTag(query.From); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+		if e := db.ValidateTag(query.To); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var count int64
+		if e := r.DB.Transaction(func(tx *gorm.DB) error {
+			n, e := db.RenameTag(tx, query.From, query.To)
+			count = n
+			return e
+		}); e != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, e.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(map[string]int64{
+			"updated": count,
+		})
+	})
+
+	router.Patch("/bulk", func(c *fiber.Ctx) error {
+		type bodyStruct struct {
+			Q      string   `json:"q"`
+			Add    []string `json:"add"`
+			Remove []string `json:"remove"`
+			Note   bool     `json:"note"`
+		}
+
+		body := new(bodyStruct)
+		if e := c.BodyParser(body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		// As in /api/card/bulk, rather than tagging all cards by an empty query
+		if strings.TrimSpace(body.Q) == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "search query is required")
+		}
+
+		for _, t := range body.Add {
+			if e := db.ValidateTag(t); e != nil {
+				return fiber.NewError(fiber.StatusBadRequest, e.Error())
+			}
+		}
+
+		var count int64
+		if e := r.DB.Transaction(func(tx *gorm.DB) error {
+			n, e := db.UpdateTags(tx, body.Q, db.TagOptions{
+				Add:    body.Add,
+				Remove: body.Remove,
+				Note:   body.Note,
+			})
+			count = n
+			return e
+		}); e != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, e.Error())
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(map[string]int64{
+			"updated": count,
+		})
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/rep2recall/r2r/db"
+	"gorm.io/gorm"
+)
+
+func (r *Router) templateRouter() {
+	router := r.Router.Group("/template")
+
+	router.Get("/all", func(c *fiber.Ctx) error {
+		query := pageStruct{}
+		if e := c.QueryParser(&query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		var templates []db.Template
+		count, e := query.paginate(db.SearchTemplates(r.DB, query.Q), &templates)
+		if e != nil {
+			return apiError(e)
+		}
+
+		type outStruct struct {
+			Result []db.LoadedTemplateStruct `json:"result"`
+			Count  int64                     `json:"count"`
+		}
+		out := outStruct{
+			Result: make([]db.LoadedTemplateStruct, 0),
+			Count:  count,
+		}
+		for _, t := range templates {
+			out.Result = append(out.Result, t.Loaded())
+		}
+
+		return c.JSON(out)
+	})
+
+	router.Get("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		template, e := db.GetTemplate(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.JSON(template)
+	})
+
+	// Cards are made for existing notes of the model
+	router.Post("/", func(c *fiber.Ctx) error {
+		body := db.LoadedTemplateStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		saved, e := r.save(db.LoadedStruct{
+			Template: []db.LoadedTemplateStruct{body},
+		}, true, func(s db.LoadSummary) db.LoadCount { return s.Template })
+		if e != nil {
+			return apiError(e)
+		}
+
+		template, e := db.GetTemplate(r.DB, saved.Template[0].ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(template)
+	})
+
+	router.Put("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		existing, e := db.GetTemplate(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		body := db.LoadedTemplateStruct{}
+		if e := c.BodyParser(&body); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+		body.ID = query.ID
+		if body.Name == "" {
+			body.Name = existing.Name
+		}
+		if body.ModelID == "" && body.Model == "" {
+			body.ModelID = existing.ModelID
+		}
+
+		if _, e := r.save(db.LoadedStruct{
+			Template: []db.LoadedTemplateStruct{body},
+		}, false, func(s db.LoadSummary) db.LoadCount { return s.Template }); e != nil {
+			return apiError(e)
+		}
+
+		template, e := db.GetTemplate(r.DB, query.ID)
+		if e != nil {
+			return apiError(e)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(template)
+	})
+
+	router.Delete("/", func(c *fiber.Ctx) error {
+		type queryStruct struct {
+			ID string `validate:"required,uuid"`
+		}
+
+		query := new(queryStruct)
+		if e := c.QueryParser(query); e != nil {
+			return fiber.NewError(fiber.StatusBadRequest, e.Error())
+		}
+
+		if e := r.DB.Transaction(func(tx *gorm.DB) error {
+			return db.DeleteTemplate(tx, query.ID)
+		}); e != nil {
+			return apiError(e)
+		}
+
+		return c.SendStatus(fiber.StatusCreated)
+	})
+}
@@ -15,7 +15,11 @@ var ExecDir string
 var UserDataDir string
 
 type SegmenterStruct struct {
-	Command []string
+	Command  []string `yaml:",omitempty"`
+	Protocol string   `yaml:",omitempty"` // exec (default, one process per text) / jsonl (long-lived process)
+	URL      string   `yaml:",omitempty"` // e.g. http://localhost:24899/tokenize, instead of Command
+	Native   string   `yaml:",omitempty"` // name of built-in segmenter (zh), instead of Command
+	Timeout  int      `yaml:",omitempty"` // in milliseconds
 }
 
 type ProxyStruct struct {
@@ -23,12 +27,21 @@ type ProxyStruct struct {
 	Command []string
 }
 
+type DeckStruct struct {
+	Query string
+	Files []string
+	State []string
+	Limit int
+	Order string
+}
+
 type ConfigStruct struct {
 	DB        string
 	Port      int
 	Secret    string
 	Proxy     map[string]ProxyStruct     // map[Path]ProxyStruct
 	Segmenter map[string]SegmenterStruct // map[Lang]SegmenterStruct
+	Deck      map[string]DeckStruct      // map[Name]DeckStruct
 }
 
 var Config ConfigStruct
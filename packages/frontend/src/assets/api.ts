@@ -16,6 +16,12 @@ export async function initAPI() {
   if (token) {
     api.defaults.headers = api.defaults.headers || {}
     api.defaults.headers['Authorization'] = `Bearer ${token}`
+
+    // Reloads when files are loaded again, e.g. by `r2r load --watch`
+    const events = new EventSource(
+      `/server/events?token=${encodeURIComponent(token)}`
+    )
+    events.addEventListener('reload', () => location.reload())
   }
 
   const { data } = await api.post<{
@@ -11,11 +11,13 @@ async function main() {
   const { searchParams } = new URL(location.href)
   const q = searchParams.get('q') || ''
   const files = searchParams.get('files') || ''
+  const deck = searchParams.get('deck') || ''
 
   const { data } = await api.post('/api/quiz/init', undefined, {
     params: {
       q,
       files,
+      deck,
       state: 'new,learning,due',
     },
   })
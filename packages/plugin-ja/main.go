@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"regexp"
@@ -84,7 +86,32 @@ func main() {
 	}
 	t = t0
 
-	if len(os.Args) > 1 {
+	if len(os.Args) > 1 && os.Args[1] == "--jsonl" {
+		// Long-lived segmenter for r2r, see `protocol: jsonl` in config.yaml
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		enc := json.NewEncoder(os.Stdout)
+
+		for scanner.Scan() {
+			var req struct {
+				ID   int    `json:"id"`
+				Text string `json:"text"`
+			}
+
+			if e := json.Unmarshal(scanner.Bytes(), &req); e != nil {
+				enc.Encode(map[string]interface{}{
+					"id":    req.ID,
+					"error": e.Error(),
+				})
+				continue
+			}
+
+			enc.Encode(map[string]interface{}{
+				"id":     req.ID,
+				"tokens": Tokenize(req.Text).SearchForm(),
+			})
+		}
+	} else if len(os.Args) > 1 {
 		fmt.Println(strings.Join(Tokenize(os.Args[1]).SearchForm(), " "))
 	} else {
 		app := fiber.New()